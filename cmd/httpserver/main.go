@@ -1,27 +1,112 @@
 package main
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
-	"fmt"
-	"io"
+	"flag"
 	"log"
-	"net/http"
+	"net"
+	"net/url"
 	"os"
 	"os/signal"
 	"strings"
 	"syscall"
-
-	"github.com/sunilpar/My-Own-Http-Server/internal/headers"
+	"time"
+
+	"github.com/sunilpar/My-Own-Http-Server/internal/accesslog"
+	"github.com/sunilpar/My-Own-Http-Server/internal/compress"
+	"github.com/sunilpar/My-Own-Http-Server/internal/fileserver"
+	"github.com/sunilpar/My-Own-Http-Server/internal/httpcache"
+	"github.com/sunilpar/My-Own-Http-Server/internal/metrics"
+	"github.com/sunilpar/My-Own-Http-Server/internal/proxy"
+	"github.com/sunilpar/My-Own-Http-Server/internal/proxyproto"
+	"github.com/sunilpar/My-Own-Http-Server/internal/ratelimit"
 	"github.com/sunilpar/My-Own-Http-Server/internal/request"
 	"github.com/sunilpar/My-Own-Http-Server/internal/response"
+	"github.com/sunilpar/My-Own-Http-Server/internal/router"
 	"github.com/sunilpar/My-Own-Http-Server/internal/server"
+	"github.com/sunilpar/My-Own-Http-Server/internal/sse"
+	"github.com/sunilpar/My-Own-Http-Server/internal/tracing"
+	"github.com/sunilpar/My-Own-Http-Server/internal/vhost"
 )
 
 const port = 42069
 
+var (
+	events        = sse.NewHub(100)
+	registry      = metrics.NewRegistry()
+	serverMetrics = metrics.NewServerMetrics(registry)
+	tracer        = tracing.NewTracer(nil)
+)
+
 func main() {
-	srv, err := server.Serve(port, myHandler)
+	metricsPath := flag.String("metrics-path", "/metrics", "path serving Prometheus metrics")
+	traceFile := flag.String("trace-file", "", "append finished spans to this file as OTLP/JSON")
+	trustedProxies := flag.String("trusted-proxies", "", "comma separated networks whose forwarding headers are believed")
+	forwardedHeader := flag.String("forwarded-header", "x-forwarded-for", `header the trusted proxies set, "x-forwarded-for" or "forwarded"`)
+	staticDir := flag.String("static-dir", "", "serve this directory under /static/")
+	proxyFrom := flag.String("proxy-protocol-from", "", "comma separated networks allowed to send a PROXY protocol header")
+	flag.Parse()
+
+	if *traceFile != "" {
+		exporter, err := tracing.NewFileExporter(*traceFile, "httpserver")
+		if err != nil {
+			log.Fatalf("Error opening trace file: %v", err)
+		}
+		defer exporter.Close()
+		tracer = tracing.NewTracer(exporter)
+	}
+
+	go publishClock()
+
+	accessLog := accesslog.New(accesslog.Config{Format: accesslog.Combined})
+	r := newRouter()
+	r.Get(*metricsPath, registry.Handler)
+	if *staticDir != "" {
+		files, err := fileserver.Dir(*staticDir, fileserver.Options{Listing: fileserver.NegotiatedListing, Precompressed: true})
+		if err != nil {
+			log.Fatalf("Error opening static directory: %v", err)
+		}
+		static := r.Group("/static", httpcache.CacheControl("public, max-age=3600"))
+		static.Get("/{path...}", files.ServeRequest)
+	}
+	sites := vhost.New()
+	sites.Default(vhost.Site{Handler: r.ServeRequest})
+	compressor, err := compress.New(compress.Config{})
+	if err != nil {
+		log.Fatalf("Error configuring compression: %v", err)
+	}
+	handler := server.NewChain(tracer.Middleware, accessLog.Middleware, serverMetrics.Middleware,
+		compressor.Middleware, compress.DecodeRequests(compress.RequestConfig{})).Then(sites.ServeRequest)
+
+	// uploads to httpbin are passed on as they arrive
+	opts := append(serverMetrics.Options(), server.WithStreamedBodies(func(req *request.Request) bool {
+		return req.RequestLine.Path == "/httpbin" || strings.HasPrefix(req.RequestLine.Path, "/httpbin/")
+	}))
+	if *trustedProxies != "" {
+		trusted, err := proxyproto.ParseCIDRs(*trustedProxies)
+		if err != nil {
+			log.Fatalf("Error parsing -trusted-proxies: %v", err)
+		}
+		proxies := request.TrustedProxies{Prefixes: trusted}
+		switch strings.ToLower(*forwardedHeader) {
+		case "x-forwarded-for":
+		case "forwarded":
+			proxies.HeaderSource = request.Forwarded
+		default:
+			log.Fatalf("Unknown -forwarded-header %q", *forwardedHeader)
+		}
+		opts = append(opts, server.WithTrustedProxies(proxies))
+	}
+	if *proxyFrom != "" {
+		trusted, err := proxyproto.ParseCIDRs(*proxyFrom)
+		if err != nil {
+			log.Fatalf("Error parsing -proxy-protocol-from: %v", err)
+		}
+		opts = append(opts, server.WithListenerWrapper(func(ln net.Listener) net.Listener {
+			return proxyproto.NewListener(ln, proxyproto.Config{Trusted: trusted})
+		}))
+	}
+
+	srv, err := server.Serve(port, handler, opts...)
 	if err != nil {
 		log.Fatalf("Error starting server: %v", err)
 	}
@@ -34,26 +119,39 @@ func main() {
 	log.Println("\n--Server gracefully stopped--")
 }
 
-func myHandler(w *response.Writer, req *request.Request) {
-	path := req.RequestLine.RequestTarget
-
-	if strings.HasPrefix(path, "/httpbin/") {
-		proxyToHttpbin(w, req, path)
-		return
-	}
-
-	if path == "/video" {
-		serveVideo(w)
-		return
-	}
+func newRouter() *router.Router {
+	r := router.New()
+	pages := r.Group("", httpcache.CacheControl("no-cache"))
+	pages.Get("/", htmlPage(response.StatusOK, successPage))
+	pages.Get("/yourproblem", htmlPage(response.StatusBadRequest, badRequestPage))
+	pages.Get("/myproblem", htmlPage(response.StatusInternalServerError, internalErrorPage))
+	r.Get("/events", events.Handler)
+
+	media := r.Group("", httpcache.CacheControl("public, max-age=86400"))
+	media.Get("/video", serveVideo)
+
+	limiter := ratelimit.New(ratelimit.Config{Rate: 5, Burst: 10})
+	httpbin := proxy.New(proxy.Config{
+		Target:   &url.URL{Scheme: "https", Host: "httpbin.org"},
+		Rewrites: []proxy.Rewrite{{Prefix: "/httpbin", Replacement: ""}},
+		Tracer:   tracer,
+		Observe:  serverMetrics.ObserveUpstream,
+	})
+	proxied := r.Group("/httpbin", limiter.Middleware)
+	proxied.Handle("", "/{path...}", httpbin.ServeRequest)
+	return r
+}
 
-	var status response.StatusCode
-	var body string
+const successPage = `
+<html>
+  <head><title>200 OK</title></head>
+  <body>
+    <h1>Success!</h1>
+    <p>Your request was an absolute banger.</p>
+  </body>
+</html>`
 
-	switch path {
-	case "/yourproblem":
-		status = response.StatusBadRequest
-		body = `
+const badRequestPage = `
 <html>
   <head><title>400 Bad Request</title></head>
   <body>
@@ -61,9 +159,8 @@ func myHandler(w *response.Writer, req *request.Request) {
     <p>Your request honestly kinda sucked.</p>
   </body>
 </html>`
-	case "/myproblem":
-		status = response.StatusInternalServerError
-		body = `
+
+const internalErrorPage = `
 <html>
   <head><title>500 Internal Server Error</title></head>
   <body>
@@ -71,102 +168,33 @@ func myHandler(w *response.Writer, req *request.Request) {
     <p>Okay, you know what? This one is on me.</p>
   </body>
 </html>`
-	default:
-		status = response.StatusOK
-		body = `
-<html>
-  <head><title>200 OK</title></head>
-  <body>
-    <h1>Success!</h1>
-    <p>Your request was an absolute banger.</p>
-  </body>
-</html>`
-	}
 
-	w.WriteStatusLine(status)
-	w.Header.Set("Content-Type", "text/html")
-	w.Header.Set("Content-Length", fmt.Sprintf("%d", len(body)))
-	w.WriteHeaders(w.Header)
-	w.WriteBody([]byte(body))
+func htmlPage(status response.StatusCode, body string) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		httpcache.ServeBytes(w, req, status, "text/html", []byte(body))
+	}
 }
 
-func proxyToHttpbin(w *response.Writer, req *request.Request, path string) {
-	targetPath := strings.TrimPrefix(path, "/httpbin")
-	url := "https://httpbin.org" + targetPath
-
-	resp, err := http.Get(url)
+func serveVideo(w *response.Writer, req *request.Request) {
+	f, err := os.Open("assets/vim.mp4")
 	if err != nil {
-		log.Printf("Error proxying request: %v", err)
-		w.WriteStatusLine(response.StatusInternalServerError)
-		w.Header.Set("Content-Type", "text/plain")
-		w.Header.Set("Content-Length", "21")
-		w.WriteHeaders(w.Header)
-		w.WriteBody([]byte("Proxying failed, sorry."))
+		log.Printf("Error opening video file: %v", err)
+		response.WriteText(w, response.StatusInternalServerError, "Video not found")
 		return
 	}
-	defer resp.Body.Close()
+	defer f.Close()
 
-	w.WriteStatusLine(response.StatusOK)
-
-	w.Header.Set("Content-Type", resp.Header.Get("Content-Type"))
-	w.Header.Del("Content-Length")
-	w.Header.Set("Transfer-Encoding", "chunked")
-	w.Header.Set("Trailer", "X-Content-SHA256, X-Content-Length")
-	w.WriteHeaders(w.Header)
-
-	var fullBody []byte
-	buf := make([]byte, 1024)
-
-	for {
-		n, err := resp.Body.Read(buf)
-		if n > 0 {
-			chunk := buf[:n]
-			fullBody = append(fullBody, chunk...)
-
-			if _, writeErr := w.WriteChunkedBody(chunk); writeErr != nil {
-				log.Println("Error writing chunk:", writeErr)
-				break
-			}
-		}
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			log.Println("Error reading response:", err)
-			break
-		}
-	}
-
-	w.WriteChunkedBodyDone()
-
-	sum := sha256.Sum256(fullBody)
-	hash := hex.EncodeToString(sum[:])
-	length := fmt.Sprintf("%d", len(fullBody))
-
-	trailer := headers.NewHeaders()
-	trailer.Set("X-Content-SHA256", hash)
-	trailer.Set("X-Content-Length", length)
-	if err := w.WriteTrailers(trailer); err != nil {
-		log.Println("Error writing trailers:", err)
+	var modtime time.Time
+	if info, err := f.Stat(); err == nil {
+		modtime = info.ModTime()
 	}
+	fileserver.ServeContent(w, req, "vim.mp4", modtime, f)
 }
 
-func serveVideo(w *response.Writer) {
-	data, err := os.ReadFile("assets/vim.mp4")
-	if err != nil {
-		log.Printf("Error reading video file: %v", err)
-		w.WriteStatusLine(response.StatusInternalServerError)
-		w.Header.Set("Content-Type", "text/plain")
-		body := "Video not found"
-		w.Header.Set("Content-Length", fmt.Sprintf("%d", len(body)))
-		w.WriteHeaders(w.Header)
-		w.WriteBody([]byte(body))
-		return
+func publishClock() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for t := range ticker.C {
+		events.Publish(sse.Event{Event: "tick", Data: t.Format(time.RFC3339)})
 	}
-
-	w.WriteStatusLine(response.StatusOK)
-	w.Header.Set("Content-Type", "video/mp4")
-	w.Header.Set("Content-Length", fmt.Sprintf("%d", len(data)))
-	w.WriteHeaders(w.Header)
-	w.WriteBody(data)
 }
@@ -0,0 +1,167 @@
+package accesslog
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sunilpar/My-Own-Http-Server/internal/request"
+	"github.com/sunilpar/My-Own-Http-Server/internal/response"
+	"github.com/sunilpar/My-Own-Http-Server/internal/server"
+)
+
+type Format int
+
+const (
+	Common Format = iota
+	Combined
+	JSON
+)
+
+type Config struct {
+	Format Format
+	// Output defaults to os.Stdout.
+	Output io.Writer
+	// SampleRate is the fraction of requests logged, between 0 and 1. Zero
+	// logs every request.
+	SampleRate float64
+	// AlwaysLogErrors logs every 4xx and 5xx response regardless of sampling.
+	AlwaysLogErrors bool
+}
+
+type Entry struct {
+	Time       time.Time
+	Method     string
+	Target     string
+	Proto      string
+	Status     int
+	Bytes      int64
+	Duration   time.Duration
+	RemoteAddr string
+	// ClientIP is the client past any trusted proxies, when known.
+	ClientIP  string
+	UserAgent string
+	Referer   string
+	RequestID string
+}
+
+type Logger struct {
+	cfg  Config
+	mu   sync.Mutex
+	out  io.Writer
+	json *slog.Logger
+}
+
+func New(cfg Config) *Logger {
+	out := cfg.Output
+	if out == nil {
+		out = os.Stdout
+	}
+	l := &Logger{cfg: cfg, out: out}
+	if cfg.Format == JSON {
+		l.json = slog.New(slog.NewJSONHandler(out, nil))
+	}
+	return l
+}
+
+// Middleware logs one entry per request once the handler has returned.
+func (l *Logger) Middleware(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		start := req.StartTime
+		if start.IsZero() {
+			start = time.Now()
+		}
+		next(w, req)
+
+		e := Entry{
+			Time:      start,
+			Method:    req.RequestLine.Method,
+			Target:    req.RequestLine.RequestTarget,
+			Proto:     "HTTP/" + req.RequestLine.HttpVersion,
+			Status:    int(w.Status()),
+			Bytes:     w.BytesWritten(),
+			Duration:  time.Since(start),
+			UserAgent: req.Headers.Get("User-Agent"),
+			Referer:   req.Headers.Get("Referer"),
+			RequestID: req.ID(),
+		}
+		if req.RemoteAddr != nil {
+			e.RemoteAddr = req.RemoteAddr.String()
+		}
+		if req.Origin.IP.IsValid() {
+			e.ClientIP = req.Origin.IP.String()
+		}
+		if l.sampled(e) {
+			l.Log(e)
+		}
+	}
+}
+
+func (l *Logger) sampled(e Entry) bool {
+	if l.cfg.SampleRate <= 0 || l.cfg.SampleRate >= 1 {
+		return true
+	}
+	if l.cfg.AlwaysLogErrors && e.Status >= 400 {
+		return true
+	}
+	return rand.Float64() < l.cfg.SampleRate
+}
+
+func (l *Logger) Log(e Entry) {
+	if l.json != nil {
+		l.json.LogAttrs(context.Background(), slog.LevelInfo, "request",
+			slog.String("method", e.Method),
+			slog.String("target", e.Target),
+			slog.String("proto", e.Proto),
+			slog.Int("status", e.Status),
+			slog.Int64("bytes", e.Bytes),
+			slog.Duration("duration", e.Duration),
+			slog.String("remote_addr", e.RemoteAddr),
+			slog.String("client_ip", e.ClientIP),
+			slog.String("user_agent", e.UserAgent),
+			slog.String("referer", e.Referer),
+			slog.String("request_id", e.RequestID),
+		)
+		return
+	}
+
+	line := formatCommon(e)
+	if l.cfg.Format == Combined {
+		line += fmt.Sprintf(" %q %q", orDash(e.Referer), orDash(e.UserAgent))
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fmt.Fprintln(l.out, line)
+}
+
+// formatCommon renders e in the NCSA Common Log Format.
+func formatCommon(e Entry) string {
+	host := e.ClientIP
+	if host == "" {
+		host = e.RemoteAddr
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+	}
+	bytes := "-"
+	if e.Bytes > 0 {
+		bytes = fmt.Sprintf("%d", e.Bytes)
+	}
+	requestLine := strings.Join([]string{e.Method, e.Target, e.Proto}, " ")
+	return fmt.Sprintf("%s - - [%s] %q %d %s",
+		orDash(host), e.Time.Format("02/Jan/2006:15:04:05 -0700"), requestLine, e.Status, bytes)
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
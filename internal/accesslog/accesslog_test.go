@@ -0,0 +1,115 @@
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunilpar/My-Own-Http-Server/internal/request"
+	"github.com/sunilpar/My-Own-Http-Server/internal/response"
+)
+
+var entry = Entry{
+	Time:       time.Date(2025, 10, 10, 13, 55, 36, 0, time.FixedZone("", -7*3600)),
+	Method:     "GET",
+	Target:     "/apache_pb.gif",
+	Proto:      "HTTP/1.1",
+	Status:     200,
+	Bytes:      2326,
+	Duration:   1500 * time.Microsecond,
+	RemoteAddr: "127.0.0.1:51234",
+	UserAgent:  "Mozilla/4.08",
+	Referer:    "http://www.example.com/start.html",
+	RequestID:  "abc",
+}
+
+func TestCombinedFormat(t *testing.T) {
+	var buf bytes.Buffer
+	New(Config{Format: Combined, Output: &buf}).Log(entry)
+	assert.Equal(t, `127.0.0.1 - - [10/Oct/2025:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.1" 200 2326 "http://www.example.com/start.html" "Mozilla/4.08"`+"\n", buf.String())
+}
+
+func TestCommonFormatWithoutBody(t *testing.T) {
+	e := entry
+	e.Bytes = 0
+	var buf bytes.Buffer
+	New(Config{Format: Common, Output: &buf}).Log(e)
+	assert.Equal(t, `127.0.0.1 - - [10/Oct/2025:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.1" 200 -`+"\n", buf.String())
+}
+
+func TestJSONFormat(t *testing.T) {
+	var buf bytes.Buffer
+	New(Config{Format: JSON, Output: &buf}).Log(entry)
+
+	var got map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &got))
+	assert.Equal(t, "request", got["msg"])
+	assert.Equal(t, "GET", got["method"])
+	assert.Equal(t, float64(200), got["status"])
+	assert.Equal(t, float64(2326), got["bytes"])
+	assert.Equal(t, float64(1500000), got["duration"])
+	assert.Equal(t, "abc", got["request_id"])
+	assert.Equal(t, "Mozilla/4.08", got["user_agent"])
+}
+
+func TestMiddlewareRecordsOutcome(t *testing.T) {
+	var buf bytes.Buffer
+	l := New(Config{Format: Combined, Output: &buf})
+
+	req, err := request.RequestFromReader(strings.NewReader("GET /x?y=1 HTTP/1.1\r\nHost: a\r\nUser-Agent: test\r\n\r\n"))
+	require.NoError(t, err)
+	conn, client := net.Pipe()
+	go io.Copy(io.Discard, client)
+	defer conn.Close()
+
+	l.Middleware(func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusNotFound)
+		w.Header.Set("Content-Length", "3")
+		w.WriteHeaders(w.Header)
+		w.WriteBody([]byte("abc"))
+	})(response.NewWriter(conn), req)
+
+	assert.Contains(t, buf.String(), `"GET /x?y=1 HTTP/1.1" 404 3 "-" "test"`)
+}
+
+func TestSampling(t *testing.T) {
+	var buf bytes.Buffer
+	l := New(Config{Output: &buf, SampleRate: 0.000001, AlwaysLogErrors: true})
+	ok := entry
+	assert.False(t, l.sampled(ok))
+	failed := entry
+	failed.Status = 503
+	assert.True(t, l.sampled(failed))
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	rf, err := OpenRotatingFile(path, 10, 2)
+	require.NoError(t, err)
+	defer rf.Close()
+
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		_, err := rf.Write([]byte(line))
+		require.NoError(t, err)
+	}
+
+	read := func(name string) string {
+		b, err := os.ReadFile(name)
+		require.NoError(t, err)
+		return string(b)
+	}
+	assert.Equal(t, "fourth\n", read(path))
+	assert.Equal(t, "third\n", read(path+".1"))
+	assert.Equal(t, "second\n", read(path+".2"))
+	_, err = os.Stat(path + ".3")
+	assert.True(t, os.IsNotExist(err))
+}
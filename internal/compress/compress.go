@@ -0,0 +1,193 @@
+// Package compress compresses responses with gzip or deflate for clients
+// that accept it, and decodes compressed request bodies.
+package compress
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"mime"
+	"strconv"
+	"strings"
+
+	"github.com/sunilpar/My-Own-Http-Server/internal/headers"
+	"github.com/sunilpar/My-Own-Http-Server/internal/request"
+	"github.com/sunilpar/My-Own-Http-Server/internal/response"
+	"github.com/sunilpar/My-Own-Http-Server/internal/server"
+)
+
+// DefaultTypes are the media types compressed when Config.Types is empty.
+var DefaultTypes = []string{
+	"text/html",
+	"text/plain",
+	"text/css",
+	"text/csv",
+	"text/xml",
+	"text/javascript",
+	"application/javascript",
+	"application/json",
+	"application/xml",
+	"image/svg+xml",
+}
+
+type Config struct {
+	// MinSize is the smallest Content-Length worth compressing; it defaults
+	// to 1024. Responses without a Content-Length are always compressed.
+	MinSize int64
+	// Types lists the media types to compress, DefaultTypes if empty.
+	Types []string
+	// Level is a compress/flate level from flate.HuffmanOnly to
+	// flate.BestCompression; zero means flate.DefaultCompression.
+	Level int
+}
+
+// Compressor is middleware choosing a content coding per response.
+type Compressor struct {
+	cfg   Config
+	types map[string]bool
+}
+
+func New(cfg Config) (*Compressor, error) {
+	if cfg.Level < flate.HuffmanOnly || cfg.Level > flate.BestCompression {
+		return nil, fmt.Errorf("compress: invalid level %d", cfg.Level)
+	}
+	if cfg.MinSize <= 0 {
+		cfg.MinSize = 1024
+	}
+	if len(cfg.Types) == 0 {
+		cfg.Types = DefaultTypes
+	}
+	if cfg.Level == 0 {
+		cfg.Level = flate.DefaultCompression
+	}
+	c := &Compressor{cfg: cfg, types: make(map[string]bool)}
+	for _, t := range cfg.Types {
+		c.types[strings.ToLower(t)] = true
+	}
+	return c, nil
+}
+
+// Middleware compresses eligible responses. The decision is taken when
+// the handler writes its headers, so it sees the final status, type and
+// length.
+func (c *Compressor) Middleware(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		// HEAD is negotiated like GET so that it gets the same headers; the
+		// writer drops its body
+		coding := Negotiate(req.Headers.Get("Accept-Encoding"), "gzip", "deflate")
+		w.SetEncoder(func(status response.StatusCode, h headers.Headers, dst io.Writer) response.BodyEncoder {
+			return c.encoder(coding, status, h, dst)
+		})
+		next(w, req)
+	}
+}
+
+func (c *Compressor) encoder(coding string, status response.StatusCode, h headers.Headers, dst io.Writer) response.BodyEncoder {
+	if status < 200 || status == response.StatusNoContent || h.Get("Content-Encoding") != "" {
+		return nil
+	}
+	if status == response.StatusNotModified || status == response.StatusPartialContent {
+		// neither is compressed here, but both stand in for a full
+		// response that may be, so caches must key them the same way
+		AddVary(h, "Accept-Encoding")
+		return nil
+	}
+	mediaType, _, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil || !c.types[mediaType] {
+		return nil
+	}
+	if cl := h.Get("Content-Length"); cl != "" {
+		if n, err := strconv.ParseInt(cl, 10, 64); err == nil && n < c.cfg.MinSize {
+			return nil
+		}
+	}
+
+	// the body depends on Accept-Encoding whether or not this client gets
+	// it compressed
+	AddVary(h, "Accept-Encoding")
+	if coding == "" {
+		return nil
+	}
+	h.Set("Content-Encoding", coding)
+	// the compressed body is a different representation with the same
+	// meaning, so a strong validator no longer holds, and byte ranges of
+	// it are not offered
+	if etag := h.Get("ETag"); strings.HasPrefix(etag, `"`) {
+		h.Set("ETag", "W/"+etag)
+	}
+	h.Del("Accept-Ranges")
+
+	// the level was checked by New, so these cannot fail
+	if coding == "gzip" {
+		zw, _ := gzip.NewWriterLevel(dst, c.cfg.Level)
+		return zw
+	}
+	fw, _ := flate.NewWriter(dst, c.cfg.Level)
+	return fw
+}
+
+// AddVary adds name to the Vary header unless it is already listed.
+func AddVary(h headers.Headers, name string) {
+	vary := h.Get("Vary")
+	for _, v := range strings.Split(vary, ",") {
+		if strings.EqualFold(strings.TrimSpace(v), name) || strings.TrimSpace(v) == "*" {
+			return
+		}
+	}
+	if vary == "" {
+		h.Set("Vary", name)
+		return
+	}
+	h.Set("Vary", vary+", "+name)
+}
+
+// Negotiate picks the content coding from supported, in order of
+// preference, that an Accept-Encoding header rates highest. It returns ""
+// when none of them is acceptable, which means the body should be sent as
+// it is. A missing header accepts nothing but identity.
+func Negotiate(acceptEncoding string, supported ...string) string {
+	if strings.TrimSpace(acceptEncoding) == "" {
+		return ""
+	}
+	q := make(map[string]float64)
+	wildcard := -1.0
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" {
+			continue
+		}
+		weight := 1.0
+		for _, p := range strings.Split(params, ";") {
+			k, v, ok := strings.Cut(strings.TrimSpace(p), "=")
+			if ok && strings.EqualFold(strings.TrimSpace(k), "q") {
+				f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+				if err != nil || f < 0 || f > 1 {
+					f = 0
+				}
+				weight = f
+			}
+		}
+		if coding == "*" {
+			wildcard = weight
+			continue
+		}
+		if coding == "x-gzip" {
+			coding = "gzip"
+		}
+		q[coding] = weight
+	}
+
+	best, bestQ := "", 0.0
+	for _, coding := range supported {
+		weight, ok := q[coding]
+		if !ok {
+			weight = max(wildcard, 0)
+		}
+		if weight > bestQ {
+			best, bestQ = coding, weight
+		}
+	}
+	return best
+}
@@ -0,0 +1,201 @@
+package compress
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net"
+	"net/http/httputil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunilpar/My-Own-Http-Server/internal/request"
+	"github.com/sunilpar/My-Own-Http-Server/internal/response"
+	"github.com/sunilpar/My-Own-Http-Server/internal/server"
+)
+
+func TestNegotiate(t *testing.T) {
+	cases := []struct {
+		header, want string
+	}{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"deflate", "deflate"},
+		{"gzip, deflate, br", "gzip"},
+		{"deflate, gzip", "gzip"},
+		{"gzip;q=0.5, deflate", "deflate"},
+		{"gzip;q=0, deflate;q=0", ""},
+		{"br", ""},
+		{"*", "gzip"},
+		{"*;q=0.1, gzip;q=0", "deflate"},
+		{"identity", ""},
+		{"x-gzip", "gzip"},
+		{"GZIP;Q=0.8", "gzip"},
+		{"gzip;q=bad", ""},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.want, Negotiate(c.header, "gzip", "deflate"), c.header)
+	}
+}
+
+// exchange runs h behind the compressor and returns the response headers
+// and a reader of the decoded body.
+func exchange(t *testing.T, h server.Handler, raw string) (head string, body *bufio.Reader) {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	c, err := New(Config{})
+	require.NoError(t, err)
+	conn, client := net.Pipe()
+	t.Cleanup(func() { client.Close() })
+	go func() {
+		w := response.NewWriter(conn)
+		if req.RequestLine.Method == "HEAD" {
+			// as the server does
+			w.DiscardBody()
+		}
+		c.Middleware(h)(w, req)
+		w.Finish()
+		conn.Close()
+	}()
+
+	br := bufio.NewReader(client)
+	var b strings.Builder
+	for {
+		line, err := br.ReadString('\n')
+		require.NoError(t, err)
+		if line == "\r\n" {
+			break
+		}
+		b.WriteString(line)
+	}
+	head = b.String()
+	if strings.HasPrefix(raw, "HEAD ") {
+		return head, br
+	}
+
+	var r io.Reader = br
+	if strings.Contains(head, "transfer-encoding: chunked\r\n") {
+		r = httputil.NewChunkedReader(br)
+	}
+	switch {
+	case strings.Contains(head, "content-encoding: gzip\r\n"):
+		zr, err := gzip.NewReader(r)
+		require.NoError(t, err)
+		r = zr
+	case strings.Contains(head, "content-encoding: deflate\r\n"):
+		r = flate.NewReader(r)
+	}
+	return head, bufio.NewReader(r)
+}
+
+func page(ctype string, size int) server.Handler {
+	body := strings.Repeat("all work and no play ", size/21+1)[:size]
+	return func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusOK)
+		w.Header.Set("Content-Type", ctype)
+		w.Header.Set("Content-Length", fmt.Sprintf("%d", len(body)))
+		w.Header.Set("ETag", `"v1"`)
+		w.WriteHeaders(w.Header)
+		w.WriteBody([]byte(body))
+	}
+}
+
+func TestNewRejectsInvalidLevel(t *testing.T) {
+	for _, level := range []int{-3, 10} {
+		_, err := New(Config{Level: level})
+		assert.Error(t, err, level)
+	}
+	for _, level := range []int{flate.HuffmanOnly, flate.DefaultCompression, 0, flate.BestSpeed, flate.BestCompression} {
+		_, err := New(Config{Level: level})
+		assert.NoError(t, err, level)
+	}
+}
+
+func TestCompressesEligibleResponses(t *testing.T) {
+	head, body := exchange(t, page("text/html; charset=utf-8", 4096), "GET / HTTP/1.1\r\nHost: a\r\nAccept-Encoding: gzip\r\n\r\n")
+	assert.Contains(t, head, "content-encoding: gzip\r\n")
+	assert.Contains(t, head, "vary: Accept-Encoding\r\n")
+	assert.Contains(t, head, "etag: W/\"v1\"\r\n")
+	assert.NotContains(t, head, "content-length")
+	data, err := io.ReadAll(body)
+	require.NoError(t, err)
+	assert.Len(t, data, 4096)
+
+	head, body = exchange(t, page("application/json", 4096), "GET / HTTP/1.1\r\nHost: a\r\nAccept-Encoding: deflate\r\n\r\n")
+	assert.Contains(t, head, "content-encoding: deflate\r\n")
+	data, err = io.ReadAll(body)
+	require.NoError(t, err)
+	assert.Len(t, data, 4096)
+}
+
+func TestLeavesOtherResponsesAlone(t *testing.T) {
+	// too small
+	head, _ := exchange(t, page("text/html", 100), "GET / HTTP/1.1\r\nHost: a\r\nAccept-Encoding: gzip\r\n\r\n")
+	assert.NotContains(t, head, "content-encoding")
+	assert.Contains(t, head, "content-length: 100\r\n")
+
+	// not a compressible type
+	head, _ = exchange(t, page("image/png", 4096), "GET / HTTP/1.1\r\nHost: a\r\nAccept-Encoding: gzip\r\n\r\n")
+	assert.NotContains(t, head, "content-encoding")
+	assert.NotContains(t, head, "vary")
+
+	// the client does not accept it, but the response still varies on it
+	head, body := exchange(t, page("text/html", 4096), "GET / HTTP/1.1\r\nHost: a\r\n\r\n")
+	assert.NotContains(t, head, "content-encoding")
+	assert.Contains(t, head, "vary: Accept-Encoding\r\n")
+	data, _ := io.ReadAll(io.LimitReader(body, 4096))
+	assert.Len(t, data, 4096)
+}
+
+func TestHeadGetsTheSameHeaders(t *testing.T) {
+	head, body := exchange(t, page("text/html", 4096), "HEAD / HTTP/1.1\r\nHost: a\r\nAccept-Encoding: gzip\r\n\r\n")
+	assert.Contains(t, head, "content-encoding: gzip\r\n")
+	assert.Contains(t, head, "vary: Accept-Encoding\r\n")
+	assert.Contains(t, head, "transfer-encoding: chunked\r\n")
+	assert.NotContains(t, head, "content-length")
+	data, err := io.ReadAll(body)
+	require.NoError(t, err)
+	assert.Empty(t, data)
+}
+
+func TestNotModifiedKeepsVary(t *testing.T) {
+	notModified := func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusNotModified)
+		w.Header.Set("ETag", `"v1"`)
+		w.WriteHeaders(w.Header)
+	}
+	head, _ := exchange(t, notModified, "GET / HTTP/1.1\r\nHost: a\r\nAccept-Encoding: gzip\r\n\r\n")
+	assert.Contains(t, head, "vary: Accept-Encoding\r\n")
+	assert.NotContains(t, head, "content-encoding")
+}
+
+func TestFlushesStreamedChunks(t *testing.T) {
+	next := make(chan struct{})
+	stream := func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusOK)
+		w.Header.Set("Content-Type", "text/plain")
+		w.Header.Set("Transfer-Encoding", "chunked")
+		w.WriteHeaders(w.Header)
+		w.WriteChunkedBody([]byte("first\n"))
+		<-next
+		w.WriteChunkedBody([]byte("second\n"))
+		w.WriteChunkedBodyDone()
+	}
+	head, body := exchange(t, stream, "GET / HTTP/1.1\r\nHost: a\r\nAccept-Encoding: gzip\r\n\r\n")
+	assert.Contains(t, head, "content-encoding: gzip\r\n")
+
+	// the first chunk arrives while the handler is still blocked
+	line, err := body.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "first\n", line)
+	close(next)
+	rest, err := io.ReadAll(body)
+	require.NoError(t, err)
+	assert.Equal(t, "second\n", string(rest))
+}
@@ -0,0 +1,113 @@
+package compress
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/sunilpar/My-Own-Http-Server/internal/request"
+	"github.com/sunilpar/My-Own-Http-Server/internal/response"
+	"github.com/sunilpar/My-Own-Http-Server/internal/server"
+)
+
+// RequestConfig bounds what DecodeRequests inflates, so a small upload
+// cannot expand into an arbitrarily large body.
+type RequestConfig struct {
+	// MaxSize caps the decompressed body; it defaults to 10 MiB.
+	MaxSize int64
+	// MaxRatio caps the decompressed size as a multiple of the compressed
+	// size; it defaults to 100.
+	MaxRatio int64
+}
+
+var (
+	errUnsupportedCoding = errors.New("unsupported content coding")
+	errTooLarge          = errors.New("decompressed body too large")
+)
+
+// DecodeRequests returns middleware that replaces a gzip or deflate encoded
+// request body with the decoded one, removing Content-Encoding and fixing
+// Content-Length. Other codings are answered with a 415, bodies over the
+// limits with a 413 and corrupt ones with a 400.
+func DecodeRequests(cfg RequestConfig) server.Middleware {
+	if cfg.MaxSize <= 0 {
+		cfg.MaxSize = 10 << 20
+	}
+	if cfg.MaxRatio <= 0 {
+		cfg.MaxRatio = 100
+	}
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			codings := req.Headers.Get("Content-Encoding")
+			if codings == "" || len(req.Body) == 0 {
+				next(w, req)
+				return
+			}
+			body, err := decodeBody(req.Body, codings, cfg)
+			switch {
+			case errors.Is(err, errUnsupportedCoding):
+				w.Header.Set("Accept-Encoding", "gzip, deflate")
+				response.WriteText(w, response.StatusUnsupportedMediaType, "Unsupported Media Type")
+				return
+			case errors.Is(err, errTooLarge):
+				w.Header.Set("Connection", "close")
+				response.WriteText(w, response.StatusContentTooLarge, "Content Too Large")
+				return
+			case err != nil:
+				response.WriteText(w, response.StatusBadRequest, "Bad Request")
+				return
+			}
+			req.Body = body
+			req.Headers.Del("Content-Encoding")
+			req.Headers.Set("Content-Length", fmt.Sprintf("%d", len(body)))
+			next(w, req)
+		}
+	}
+}
+
+// decodeBody undoes the codings listed in a Content-Encoding header, last
+// applied first.
+func decodeBody(body []byte, codings string, cfg RequestConfig) ([]byte, error) {
+	limit := min(cfg.MaxSize, int64(len(body))*cfg.MaxRatio)
+	list := strings.Split(codings, ",")
+	for i := len(list) - 1; i >= 0; i-- {
+		coding := strings.ToLower(strings.TrimSpace(list[i]))
+		var r io.Reader
+		var err error
+		switch coding {
+		case "identity":
+			continue
+		case "gzip", "x-gzip":
+			r, err = gzip.NewReader(bytes.NewReader(body))
+		case "deflate":
+			r, err = deflateReader(body)
+		default:
+			return nil, errUnsupportedCoding
+		}
+		if err != nil {
+			return nil, err
+		}
+		body, err = io.ReadAll(io.LimitReader(r, limit+1))
+		if err != nil {
+			return nil, err
+		}
+		if int64(len(body)) > limit {
+			return nil, errTooLarge
+		}
+	}
+	return body, nil
+}
+
+// deflateReader reads "deflate", which is zlib-wrapped, but also accepts
+// the raw deflate streams some clients send instead.
+func deflateReader(body []byte) (io.Reader, error) {
+	if len(body) >= 2 && body[0]&0x0f == 8 && (uint16(body[0])<<8|uint16(body[1]))%31 == 0 {
+		return zlib.NewReader(bytes.NewReader(body))
+	}
+	return flate.NewReader(bytes.NewReader(body)), nil
+}
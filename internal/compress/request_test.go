@@ -0,0 +1,93 @@
+package compress
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunilpar/My-Own-Http-Server/internal/request"
+	"github.com/sunilpar/My-Own-Http-Server/internal/response"
+	"github.com/sunilpar/My-Own-Http-Server/internal/testutil"
+)
+
+func encode(t *testing.T, coding string, data []byte) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	var w io.WriteCloser
+	switch coding {
+	case "gzip":
+		w = gzip.NewWriter(&b)
+	case "deflate":
+		w = zlib.NewWriter(&b)
+	case "raw":
+		w, _ = flate.NewWriter(&b, flate.DefaultCompression)
+	}
+	_, err := w.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return b.Bytes()
+}
+
+// upload posts body with the given Content-Encoding through DecodeRequests
+// and returns the response along with the body the handler saw.
+func upload(t *testing.T, cfg RequestConfig, coding string, body []byte) (resp string, got []byte) {
+	t.Helper()
+	raw := fmt.Sprintf("POST /telemetry HTTP/1.1\r\nHost: a\r\nContent-Encoding: %s\r\nContent-Length: %d\r\n\r\n", coding, len(body))
+	req, err := request.RequestFromReader(io.MultiReader(strings.NewReader(raw), bytes.NewReader(body)))
+	require.NoError(t, err)
+
+	h := DecodeRequests(cfg)(func(w *response.Writer, req *request.Request) {
+		got = req.Body
+		assert.Empty(t, req.Headers.Get("Content-Encoding"))
+		assert.Equal(t, fmt.Sprintf("%d", len(req.Body)), req.Headers.Get("Content-Length"))
+		w.WriteStatusLine(response.StatusNoContent)
+		w.WriteHeaders(w.Header)
+	})
+	return testutil.ServeRequest(t, h, req), got
+}
+
+func TestDecodeRequests(t *testing.T) {
+	data := []byte(`{"cpu":0.5,"mem":1024}`)
+	for _, coding := range []string{"gzip", "deflate"} {
+		resp, got := upload(t, RequestConfig{}, coding, encode(t, coding, data))
+		assert.Equal(t, "HTTP/1.1 204 No Content", testutil.Status(resp), coding)
+		assert.Equal(t, data, got, coding)
+	}
+
+	// raw deflate without the zlib wrapper is accepted too
+	_, got := upload(t, RequestConfig{}, "deflate", encode(t, "raw", data))
+	assert.Equal(t, data, got)
+
+	// codings are undone last first
+	_, got = upload(t, RequestConfig{}, "deflate, gzip", encode(t, "gzip", encode(t, "deflate", data)))
+	assert.Equal(t, data, got)
+}
+
+func TestDecodeRequestsRejects(t *testing.T) {
+	resp, got := upload(t, RequestConfig{}, "br", []byte("whatever"))
+	assert.Equal(t, "HTTP/1.1 415 Unsupported Media Type", testutil.Status(resp))
+	assert.Contains(t, resp, "accept-encoding: gzip, deflate\r\n")
+	assert.Nil(t, got)
+
+	resp, _ = upload(t, RequestConfig{}, "gzip", []byte("not gzip at all"))
+	assert.Equal(t, "HTTP/1.1 400 Bad Request", testutil.Status(resp))
+
+	// a zip bomb: 1 MiB of zeros compresses about a thousandfold
+	bomb := encode(t, "gzip", make([]byte, 1<<20))
+	resp, _ = upload(t, RequestConfig{}, "gzip", bomb)
+	assert.Equal(t, "HTTP/1.1 413 Content Too Large", testutil.Status(resp))
+
+	resp, _ = upload(t, RequestConfig{MaxRatio: 10000, MaxSize: 1 << 10}, "gzip", bomb)
+	assert.Equal(t, "HTTP/1.1 413 Content Too Large", testutil.Status(resp))
+
+	_, got = upload(t, RequestConfig{MaxRatio: 10000}, "gzip", bomb)
+	assert.Len(t, got, 1<<20)
+}
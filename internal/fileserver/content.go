@@ -0,0 +1,176 @@
+package fileserver
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"path"
+	"time"
+
+	"github.com/sunilpar/My-Own-Http-Server/internal/httpcache"
+	"github.com/sunilpar/My-Own-Http-Server/internal/request"
+	"github.com/sunilpar/My-Own-Http-Server/internal/response"
+)
+
+// ServeContent streams content in reply to req, honouring conditional
+// requests, Range and If-Range. name is only used to pick a Content-Type
+// when none is set; the first 512 bytes are sniffed if its extension is
+// unknown. modtime, if not zero, is sent as Last-Modified and, unless the
+// caller set an ETag, used with the size to make a strong one.
+func ServeContent(w *response.Writer, req *request.Request, name string, modtime time.Time, content io.ReadSeeker) {
+	size, err := content.Seek(0, io.SeekEnd)
+	if err == nil {
+		_, err = content.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		writeFSError(w, err)
+		return
+	}
+
+	setValidators(w, modtime, size)
+	if httpcache.CheckPreconditions(w, req, modtime) {
+		return
+	}
+
+	if w.Header.Get("Content-Type") == "" {
+		ctype := TypeByExtension(path.Ext(name))
+		if ctype == "" {
+			head := make([]byte, 512)
+			n, err := io.ReadFull(content, head)
+			if err == nil || err == io.ErrUnexpectedEOF || err == io.EOF {
+				_, err = content.Seek(0, io.SeekStart)
+			}
+			if err != nil {
+				writeFSError(w, err)
+				return
+			}
+			ctype = http.DetectContentType(head[:n])
+		}
+		w.Header.Set("Content-Type", ctype)
+	}
+	w.Header.Set("Accept-Ranges", "bytes")
+
+	var ranges []byteRange
+	if rh := req.Headers.Get("Range"); rh != "" && req.RequestLine.Method == "GET" && ifRangeMatches(req, w, modtime) {
+		ranges, err = parseRange(rh, size)
+		switch {
+		case errors.Is(err, errUnsatisfiableRange):
+			w.Header.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+			w.Header.Del("Content-Type")
+			response.WriteText(w, response.StatusRangeNotSatisfiable, "Range Not Satisfiable")
+			return
+		case err != nil:
+			ranges = nil
+		}
+		// asking for more than the whole, e.g. with overlapping ranges, is
+		// answered with the whole
+		var total int64
+		for _, r := range ranges {
+			total += r.length
+		}
+		if total > size {
+			ranges = nil
+		}
+	}
+
+	switch len(ranges) {
+	case 0:
+		w.WriteStatusLine(response.StatusOK)
+		w.Header.Set("Content-Length", fmt.Sprintf("%d", size))
+		w.WriteHeaders(w.Header)
+		if req.RequestLine.Method != "HEAD" {
+			copyBody(w, name, content, size)
+		}
+	case 1:
+		r := ranges[0]
+		w.WriteStatusLine(response.StatusPartialContent)
+		w.Header.Set("Content-Range", r.contentRange(size))
+		w.Header.Set("Content-Length", fmt.Sprintf("%d", r.length))
+		w.WriteHeaders(w.Header)
+		if _, err := content.Seek(r.start, io.SeekStart); err != nil {
+			log.Printf("Error seeking in %s: %v", name, err)
+			return
+		}
+		copyBody(w, name, content, r.length)
+	default:
+		serveMultipart(w, name, content, size, ranges)
+	}
+}
+
+// serveMultipart sends ranges as a multipart/byteranges body. Its length is
+// worked out up front by writing the part headers alone.
+func serveMultipart(w *response.Writer, name string, content io.ReadSeeker, size int64, ranges []byteRange) {
+	ctype := w.Header.Get("Content-Type")
+	partHeader := func(r byteRange) textproto.MIMEHeader {
+		return textproto.MIMEHeader{
+			"Content-Range": {r.contentRange(size)},
+			"Content-Type":  {ctype},
+		}
+	}
+
+	counter := &countingWriter{}
+	mw := multipart.NewWriter(counter)
+	for _, r := range ranges {
+		mw.CreatePart(partHeader(r))
+		counter.n += r.length
+	}
+	mw.Close()
+
+	w.WriteStatusLine(response.StatusPartialContent)
+	w.Header.Set("Content-Type", "multipart/byteranges; boundary="+mw.Boundary())
+	w.Header.Set("Content-Length", fmt.Sprintf("%d", counter.n))
+	w.WriteHeaders(w.Header)
+
+	body := multipart.NewWriter(bodyWriter{w})
+	body.SetBoundary(mw.Boundary())
+	for _, r := range ranges {
+		part, err := body.CreatePart(partHeader(r))
+		if err != nil {
+			return
+		}
+		if _, err := content.Seek(r.start, io.SeekStart); err != nil {
+			log.Printf("Error seeking in %s: %v", name, err)
+			return
+		}
+		if _, err := io.CopyN(part, content, r.length); err != nil {
+			log.Printf("Error sending %s: %v", name, err)
+			return
+		}
+	}
+	body.Close()
+}
+
+func setValidators(w *response.Writer, modtime time.Time, size int64) {
+	if modtime.IsZero() || modtime.Unix() == 0 {
+		return
+	}
+	w.Header.Set("Last-Modified", modtime.UTC().Format(httpcache.TimeFormat))
+	if w.Header.Get("ETag") == "" {
+		w.Header.Set("ETag", httpcache.FileETag(modtime, size))
+	}
+}
+
+// copyBody sends n bytes of content through w.ReadFrom, which an *os.File
+// can do with sendfile.
+func copyBody(w *response.Writer, name string, content io.Reader, n int64) {
+	sent, err := w.ReadFrom(io.LimitReader(content, n))
+	if err == nil && sent < n {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		log.Printf("Error sending %s: %v", name, err)
+	}
+}
+
+type countingWriter struct {
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
@@ -0,0 +1,318 @@
+// Package fileserver serves static files from a directory or any fs.FS.
+package fileserver
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/sunilpar/My-Own-Http-Server/internal/compress"
+	"github.com/sunilpar/My-Own-Http-Server/internal/httpcache"
+	"github.com/sunilpar/My-Own-Http-Server/internal/request"
+	"github.com/sunilpar/My-Own-Http-Server/internal/response"
+)
+
+type ListingFormat int
+
+const (
+	NoListing ListingFormat = iota
+	HTMLListing
+	JSONListing
+	// NegotiatedListing sends JSON to clients that accept application/json
+	// and HTML to everyone else.
+	NegotiatedListing
+)
+
+type SymlinkPolicy int
+
+const (
+	// SymlinksWithinRoot follows links whose target stays inside the root.
+	SymlinksWithinRoot SymlinkPolicy = iota
+	SymlinksDeny
+	SymlinksFollow
+)
+
+type Options struct {
+	// Listing is used for directories without an index file. With
+	// NoListing they are a 404.
+	Listing ListingFormat
+	// ServeHidden serves names starting with a dot. By default they are a
+	// 404 and left out of listings.
+	ServeHidden bool
+	// Symlinks only applies to servers created with Dir; an fs.FS decides
+	// for itself what its links mean.
+	Symlinks SymlinkPolicy
+	// IndexFile is served for directory requests. It defaults to
+	// index.html.
+	IndexFile string
+	// PathParam names the router wildcard holding the file path, "path" by
+	// default, as in "/static/{path...}". Requests that did not go through
+	// the router use the whole request path.
+	PathParam string
+	// Precompressed serves name.gz with Content-Encoding: gzip in place of
+	// name to clients accepting gzip, when it exists and is not older.
+	Precompressed bool
+}
+
+type FileServer struct {
+	fsys fs.FS
+	root string // real path of the root directory, set by Dir
+	opts Options
+}
+
+func New(fsys fs.FS, opts Options) *FileServer {
+	if opts.IndexFile == "" {
+		opts.IndexFile = "index.html"
+	}
+	if opts.PathParam == "" {
+		opts.PathParam = "path"
+	}
+	return &FileServer{fsys: fsys, opts: opts}
+}
+
+// Dir serves the directory root from the operating system, applying
+// opts.Symlinks to every link on the way to a file.
+func Dir(root string, opts Options) (*FileServer, error) {
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	real, err := filepath.EvalSymlinks(abs)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(real)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("fileserver: %s is not a directory", root)
+	}
+	f := New(os.DirFS(real), opts)
+	f.root = real
+	return f, nil
+}
+
+func (f *FileServer) ServeRequest(w *response.Writer, req *request.Request) {
+	if req.RequestLine.Method != "GET" && req.RequestLine.Method != "HEAD" {
+		w.Header.Set("Allow", "GET, HEAD")
+		response.WriteText(w, response.StatusMethodNotAllowed, "Method Not Allowed")
+		return
+	}
+	name, ok := f.resolve(req)
+	if !ok {
+		response.WriteText(w, response.StatusNotFound, "Not Found")
+		return
+	}
+	info, err := fs.Stat(f.fsys, name)
+	if err != nil {
+		writeFSError(w, err)
+		return
+	}
+	if !info.IsDir() {
+		f.serveFile(w, req, name, info)
+		return
+	}
+
+	if !strings.HasSuffix(req.RequestLine.Path, "/") {
+		// a relative redirect cannot be steered to another host
+		target := url.PathEscape(path.Base(req.RequestLine.Path)) + "/"
+		if req.RequestLine.RawQuery != "" {
+			target += "?" + req.RequestLine.RawQuery
+		}
+		w.Header.Set("Location", target)
+		response.WriteText(w, response.StatusMovedPermanently, "Moved Permanently")
+		return
+	}
+	index := path.Join(name, f.opts.IndexFile)
+	if info, err := fs.Stat(f.fsys, index); err == nil && !info.IsDir() && f.allowed(index) {
+		f.serveFile(w, req, index, info)
+		return
+	}
+	if f.opts.Listing == NoListing {
+		response.WriteText(w, response.StatusNotFound, "Not Found")
+		return
+	}
+	f.serveListing(w, req, name)
+}
+
+// resolve maps the request onto a name in the file system, rejecting
+// anything that would leave the root or is hidden by policy.
+func (f *FileServer) resolve(req *request.Request) (string, bool) {
+	p := req.RequestLine.Path
+	if req.Pattern != "" {
+		p = "/" + req.PathValue(f.opts.PathParam)
+	}
+	if strings.ContainsAny(p, "\x00\\") {
+		return "", false
+	}
+	name := strings.Trim(request.CleanPath(p), "/")
+	if name == "" {
+		name = "."
+	}
+	if !fs.ValidPath(name) {
+		return "", false
+	}
+	if !f.opts.ServeHidden {
+		for _, part := range strings.Split(name, "/") {
+			if hidden(part) {
+				return "", false
+			}
+		}
+	}
+	return name, f.allowed(name)
+}
+
+func hidden(name string) bool {
+	return strings.HasPrefix(name, ".") && name != "."
+}
+
+// allowed applies the symlink policy to name. A name that does not exist is
+// allowed here and fails later with a 404.
+func (f *FileServer) allowed(name string) bool {
+	if f.root == "" || f.opts.Symlinks == SymlinksFollow || name == "." {
+		return true
+	}
+	full := filepath.Join(f.root, filepath.FromSlash(name))
+	if f.opts.Symlinks == SymlinksDeny {
+		cur := f.root
+		for _, part := range strings.Split(name, "/") {
+			cur = filepath.Join(cur, part)
+			info, err := os.Lstat(cur)
+			if err != nil {
+				return true
+			}
+			if info.Mode()&fs.ModeSymlink != 0 {
+				return false
+			}
+		}
+		return true
+	}
+	real, err := filepath.EvalSymlinks(full)
+	if err != nil {
+		return true
+	}
+	return real == f.root || strings.HasPrefix(real, f.root+string(filepath.Separator))
+}
+
+func (f *FileServer) serveFile(w *response.Writer, req *request.Request, name string, info fs.FileInfo) {
+	if f.opts.Precompressed {
+		if gz, gzInfo, ok := f.precompressed(name, info); ok {
+			compress.AddVary(w.Header, "Accept-Encoding")
+			if compress.Negotiate(req.Headers.Get("Accept-Encoding"), "gzip") == "gzip" {
+				ctype, err := f.contentType(name)
+				if err != nil {
+					writeFSError(w, err)
+					return
+				}
+				// the sibling is its own representation, with its own
+				// validators and byte ranges
+				w.Header.Set("Content-Type", ctype)
+				w.Header.Set("Content-Encoding", "gzip")
+				name, info = gz, gzInfo
+			}
+		}
+	}
+
+	file, err := f.fsys.Open(name)
+	if err != nil {
+		writeFSError(w, err)
+		return
+	}
+	defer file.Close()
+	if rs, ok := file.(io.ReadSeeker); ok {
+		ServeContent(w, req, name, info.ModTime(), rs)
+		return
+	}
+
+	// without Seek there are no ranges and the sniffed bytes are put back
+	// in front of the rest
+	var body io.Reader = file
+	ctype := w.Header.Get("Content-Type")
+	if ctype == "" {
+		ctype = TypeByExtension(path.Ext(name))
+	}
+	if ctype == "" {
+		head := make([]byte, 512)
+		n, err := io.ReadFull(file, head)
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			writeFSError(w, err)
+			return
+		}
+		ctype = http.DetectContentType(head[:n])
+		body = io.MultiReader(bytes.NewReader(head[:n]), file)
+	}
+
+	setValidators(w, info.ModTime(), info.Size())
+	if httpcache.CheckPreconditions(w, req, info.ModTime()) {
+		return
+	}
+
+	w.WriteStatusLine(response.StatusOK)
+	w.Header.Set("Content-Type", ctype)
+	w.Header.Set("Content-Length", fmt.Sprintf("%d", info.Size()))
+	w.WriteHeaders(w.Header)
+	if req.RequestLine.Method != "HEAD" {
+		copyBody(w, name, body, info.Size())
+	}
+}
+
+// precompressed finds the gzip sibling of name. One older than name is
+// taken to be left over from a previous build and ignored.
+func (f *FileServer) precompressed(name string, info fs.FileInfo) (string, fs.FileInfo, bool) {
+	gz := name + ".gz"
+	gzInfo, err := fs.Stat(f.fsys, gz)
+	if err != nil || !gzInfo.Mode().IsRegular() || gzInfo.ModTime().Before(info.ModTime()) || !f.allowed(gz) {
+		return "", nil, false
+	}
+	return gz, gzInfo, true
+}
+
+// contentType is the type of name by its extension or, failing that, its
+// first 512 bytes.
+func (f *FileServer) contentType(name string) (string, error) {
+	if ctype := TypeByExtension(path.Ext(name)); ctype != "" {
+		return ctype, nil
+	}
+	file, err := f.fsys.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	return http.DetectContentType(head[:n]), nil
+}
+
+// bodyWriter adapts a response.Writer to io.Writer.
+type bodyWriter struct {
+	w *response.Writer
+}
+
+func (b bodyWriter) Write(p []byte) (int, error) {
+	return b.w.WriteBody(p)
+}
+
+func writeFSError(w *response.Writer, err error) {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		response.WriteText(w, response.StatusNotFound, "Not Found")
+	case errors.Is(err, fs.ErrPermission):
+		response.WriteText(w, response.StatusForbidden, "Forbidden")
+	default:
+		log.Printf("Error serving file: %v", err)
+		response.WriteText(w, response.StatusInternalServerError, "Internal Server Error")
+	}
+}
@@ -0,0 +1,217 @@
+package fileserver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunilpar/My-Own-Http-Server/internal/httpcache"
+	"github.com/sunilpar/My-Own-Http-Server/internal/request"
+	"github.com/sunilpar/My-Own-Http-Server/internal/testutil"
+)
+
+func testFS() fstest.MapFS {
+	return fstest.MapFS{
+		"index.html":        {Data: []byte("<h1>home</h1>")},
+		"css/site.css":      {Data: []byte("body{}")},
+		"docs/readme":       {Data: []byte("%PDF-1.7 not really")},
+		"docs/a b.txt":      {Data: []byte("spaced")},
+		"docs/.secret":      {Data: []byte("hidden")},
+		".git/config":       {Data: []byte("[core]")},
+		"empty/placeholder": {Data: nil},
+	}
+}
+
+func TestServesFilesWithContentType(t *testing.T) {
+	f := New(testFS(), Options{})
+
+	resp := testutil.Serve(t, f.ServeRequest, "GET /css/site.css HTTP/1.1\r\nHost: a\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 200 OK", testutil.Status(resp))
+	assert.Contains(t, resp, "content-type: text/css; charset=utf-8\r\n")
+	assert.Contains(t, resp, "content-length: 6\r\n")
+	assert.Equal(t, "body{}", testutil.Body(resp))
+
+	// no extension, so the type is sniffed from the content
+	resp = testutil.Serve(t, f.ServeRequest, "GET /docs/readme HTTP/1.1\r\nHost: a\r\n\r\n")
+	assert.Contains(t, resp, "content-type: application/pdf\r\n")
+	assert.Equal(t, "%PDF-1.7 not really", testutil.Body(resp))
+
+	resp = testutil.Serve(t, f.ServeRequest, "HEAD /docs/a%20b.txt HTTP/1.1\r\nHost: a\r\n\r\n")
+	assert.Contains(t, resp, "content-length: 6\r\n")
+	assert.Empty(t, testutil.Body(resp))
+
+	resp = testutil.Serve(t, f.ServeRequest, "POST /index.html HTTP/1.1\r\nHost: a\r\nContent-Length: 0\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 405 Method Not Allowed", testutil.Status(resp))
+	assert.Contains(t, resp, "allow: GET, HEAD\r\n")
+}
+
+func TestIndexAndDirectoryRedirect(t *testing.T) {
+	f := New(testFS(), Options{})
+
+	resp := testutil.Serve(t, f.ServeRequest, "GET / HTTP/1.1\r\nHost: a\r\n\r\n")
+	assert.Equal(t, "<h1>home</h1>", testutil.Body(resp))
+
+	resp = testutil.Serve(t, f.ServeRequest, "GET /docs?x=1 HTTP/1.1\r\nHost: a\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 301 Moved Permanently", testutil.Status(resp))
+	assert.Contains(t, resp, "location: docs/?x=1\r\n")
+
+	// no index and listings are off
+	resp = testutil.Serve(t, f.ServeRequest, "GET /docs/ HTTP/1.1\r\nHost: a\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 404 Not Found", testutil.Status(resp))
+}
+
+func TestHiddenFiles(t *testing.T) {
+	f := New(testFS(), Options{})
+	assert.Equal(t, "HTTP/1.1 404 Not Found", testutil.Status(testutil.Serve(t, f.ServeRequest, "GET /docs/.secret HTTP/1.1\r\nHost: a\r\n\r\n")))
+	assert.Equal(t, "HTTP/1.1 404 Not Found", testutil.Status(testutil.Serve(t, f.ServeRequest, "GET /.git/config HTTP/1.1\r\nHost: a\r\n\r\n")))
+
+	f = New(testFS(), Options{ServeHidden: true})
+	assert.Equal(t, "hidden", testutil.Body(testutil.Serve(t, f.ServeRequest, "GET /docs/.secret HTTP/1.1\r\nHost: a\r\n\r\n")))
+}
+
+func TestListings(t *testing.T) {
+	f := New(testFS(), Options{Listing: NegotiatedListing})
+
+	resp := testutil.Serve(t, f.ServeRequest, "GET /docs/ HTTP/1.1\r\nHost: a\r\n\r\n")
+	assert.Contains(t, resp, "content-type: text/html; charset=utf-8\r\n")
+	assert.Contains(t, testutil.Body(resp), `<a href="./a%20b.txt">a b.txt</a>`)
+	assert.Contains(t, testutil.Body(resp), `<a href="../">../</a>`)
+	assert.NotContains(t, testutil.Body(resp), ".secret")
+
+	resp = testutil.Serve(t, f.ServeRequest, "GET /docs/ HTTP/1.1\r\nHost: a\r\nAccept: application/json\r\n\r\n")
+	assert.Contains(t, resp, "content-type: application/json\r\n")
+	var listing struct {
+		Path    string
+		Entries []struct {
+			Name  string
+			IsDir bool `json:"is_dir"`
+			Size  int64
+		}
+	}
+	require.NoError(t, json.Unmarshal([]byte(testutil.Body(resp)), &listing))
+	assert.Equal(t, "/docs/", listing.Path)
+	require.Len(t, listing.Entries, 2)
+	assert.Equal(t, "a b.txt", listing.Entries[0].Name)
+	assert.Equal(t, int64(6), listing.Entries[0].Size)
+	assert.Equal(t, "readme", listing.Entries[1].Name)
+}
+
+func TestRoutedPathParam(t *testing.T) {
+	f := New(testFS(), Options{})
+	req, err := request.RequestFromReader(strings.NewReader("GET /static/css/site.css HTTP/1.1\r\nHost: a\r\n\r\n"))
+	require.NoError(t, err)
+	req.Pattern = "/static/{path...}"
+	req.SetPathValue("path", "css/site.css")
+	assert.Equal(t, "body{}", testutil.Body(testutil.ServeRequest(t, f.ServeRequest, req)))
+
+	// the wildcard value is cleaned again before use
+	req.SetPathValue("path", "../../etc/passwd")
+	assert.Equal(t, "HTTP/1.1 404 Not Found", testutil.Status(testutil.ServeRequest(t, f.ServeRequest, req)))
+}
+
+func TestDirSymlinkPolicies(t *testing.T) {
+	outside := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("outside"), 0o644))
+
+	root := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(root, "real.txt"), []byte("inside"), 0o644))
+	require.NoError(t, os.Symlink(filepath.Join(root, "real.txt"), filepath.Join(root, "link.txt")))
+	require.NoError(t, os.Symlink(outside, filepath.Join(root, "escape")))
+
+	f, err := Dir(root, Options{Listing: HTMLListing})
+	require.NoError(t, err)
+	assert.Equal(t, "inside", testutil.Body(testutil.Serve(t, f.ServeRequest, "GET /link.txt HTTP/1.1\r\nHost: a\r\n\r\n")))
+	assert.Equal(t, "HTTP/1.1 404 Not Found", testutil.Status(testutil.Serve(t, f.ServeRequest, "GET /escape/secret.txt HTTP/1.1\r\nHost: a\r\n\r\n")))
+	listing := testutil.Body(testutil.Serve(t, f.ServeRequest, "GET / HTTP/1.1\r\nHost: a\r\n\r\n"))
+	assert.Contains(t, listing, "link.txt")
+	assert.NotContains(t, listing, "escape")
+
+	f, err = Dir(root, Options{Symlinks: SymlinksDeny})
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 404 Not Found", testutil.Status(testutil.Serve(t, f.ServeRequest, "GET /link.txt HTTP/1.1\r\nHost: a\r\n\r\n")))
+	assert.Equal(t, "inside", testutil.Body(testutil.Serve(t, f.ServeRequest, "GET /real.txt HTTP/1.1\r\nHost: a\r\n\r\n")))
+
+	f, err = Dir(root, Options{Symlinks: SymlinksFollow})
+	require.NoError(t, err)
+	assert.Equal(t, "outside", testutil.Body(testutil.Serve(t, f.ServeRequest, "GET /escape/secret.txt HTTP/1.1\r\nHost: a\r\n\r\n")))
+}
+
+func gzipped(t *testing.T, s string) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	zw := gzip.NewWriter(&b)
+	_, err := zw.Write([]byte(s))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	return b.Bytes()
+}
+
+func TestPrecompressed(t *testing.T) {
+	now := time.Now()
+	js := gzipped(t, "console.log('hi')")
+	fsys := fstest.MapFS{
+		"app.js":       {Data: []byte("console.log('hi')"), ModTime: now},
+		"app.js.gz":    {Data: js, ModTime: now},
+		"stale.css":    {Data: []byte("a{}"), ModTime: now},
+		"stale.css.gz": {Data: gzipped(t, "b{}"), ModTime: now.Add(-time.Hour)},
+		"plain.txt":    {Data: []byte("plain"), ModTime: now},
+		"noext.gz":     {Data: gzipped(t, "%PDF-1.7"), ModTime: now},
+		"noext":        {Data: []byte("%PDF-1.7"), ModTime: now},
+	}
+	f := New(fsys, Options{Precompressed: true})
+
+	resp := testutil.Serve(t, f.ServeRequest, "GET /app.js HTTP/1.1\r\nHost: a\r\nAccept-Encoding: br, gzip\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 200 OK", testutil.Status(resp))
+	assert.Contains(t, resp, "content-encoding: gzip\r\n")
+	assert.Contains(t, resp, "content-type: text/javascript; charset=utf-8\r\n")
+	assert.Contains(t, resp, "vary: Accept-Encoding\r\n")
+	assert.Contains(t, resp, fmt.Sprintf("content-length: %d\r\n", len(js)))
+	assert.Equal(t, string(js), testutil.Body(resp))
+	gzETag := httpcache.FileETag(now, int64(len(js)))
+	assert.Contains(t, resp, "etag: "+gzETag+"\r\n")
+
+	// identity has its own validator, and both vary on Accept-Encoding
+	resp = testutil.Serve(t, f.ServeRequest, "GET /app.js HTTP/1.1\r\nHost: a\r\n\r\n")
+	assert.NotContains(t, resp, "content-encoding")
+	assert.Contains(t, resp, "vary: Accept-Encoding\r\n")
+	assert.Contains(t, resp, "etag: "+httpcache.FileETag(now, 17)+"\r\n")
+	assert.Equal(t, "console.log('hi')", testutil.Body(resp))
+
+	resp = testutil.Serve(t, f.ServeRequest, "GET /app.js HTTP/1.1\r\nHost: a\r\nAccept-Encoding: gzip;q=0\r\n\r\n")
+	assert.NotContains(t, resp, "content-encoding")
+
+	// conditional requests and ranges apply to the gzip bytes
+	resp = testutil.Serve(t, f.ServeRequest, "GET /app.js HTTP/1.1\r\nHost: a\r\nAccept-Encoding: gzip\r\nIf-None-Match: "+gzETag+"\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 304 Not Modified", testutil.Status(resp))
+	assert.Contains(t, resp, "vary: Accept-Encoding\r\n")
+	resp = testutil.Serve(t, f.ServeRequest, "GET /app.js HTTP/1.1\r\nHost: a\r\nAccept-Encoding: gzip\r\nRange: bytes=0-1\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 206 Partial Content", testutil.Status(resp))
+	assert.Contains(t, resp, fmt.Sprintf("content-range: bytes 0-1/%d\r\n", len(js)))
+	assert.Equal(t, "\x1f\x8b", testutil.Body(resp))
+
+	// a sibling older than the file is ignored
+	resp = testutil.Serve(t, f.ServeRequest, "GET /stale.css HTTP/1.1\r\nHost: a\r\nAccept-Encoding: gzip\r\n\r\n")
+	assert.Equal(t, "a{}", testutil.Body(resp))
+
+	// the type of the original is sniffed if its extension says nothing
+	resp = testutil.Serve(t, f.ServeRequest, "GET /noext HTTP/1.1\r\nHost: a\r\nAccept-Encoding: gzip\r\n\r\n")
+	assert.Contains(t, resp, "content-type: application/pdf\r\n")
+	assert.Contains(t, resp, "content-encoding: gzip\r\n")
+
+	resp = testutil.Serve(t, f.ServeRequest, "GET /plain.txt HTTP/1.1\r\nHost: a\r\nAccept-Encoding: gzip\r\n\r\n")
+	assert.NotContains(t, resp, "vary")
+
+	// off by default
+	resp = testutil.Serve(t, New(fsys, Options{}).ServeRequest, "GET /app.js HTTP/1.1\r\nHost: a\r\nAccept-Encoding: gzip\r\n\r\n")
+	assert.NotContains(t, resp, "content-encoding")
+}
@@ -0,0 +1,100 @@
+package fileserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"io/fs"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/sunilpar/My-Own-Http-Server/internal/request"
+	"github.com/sunilpar/My-Own-Http-Server/internal/response"
+)
+
+type listingEntry struct {
+	Name    string    `json:"name"`
+	IsDir   bool      `json:"is_dir"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+func (f *FileServer) serveListing(w *response.Writer, req *request.Request, name string) {
+	dirEntries, err := fs.ReadDir(f.fsys, name)
+	if err != nil {
+		writeFSError(w, err)
+		return
+	}
+	entries := make([]listingEntry, 0, len(dirEntries))
+	for _, d := range dirEntries {
+		if (!f.opts.ServeHidden && hidden(d.Name())) || !f.allowed(path.Join(name, d.Name())) {
+			continue
+		}
+		info, err := d.Info()
+		if err != nil {
+			continue
+		}
+		e := listingEntry{Name: d.Name(), IsDir: d.IsDir(), ModTime: info.ModTime().UTC()}
+		if !e.IsDir {
+			e.Size = info.Size()
+		}
+		entries = append(entries, e)
+	}
+
+	format := f.opts.Listing
+	if format == NegotiatedListing {
+		format = HTMLListing
+		if strings.Contains(req.Headers.Get("Accept"), "application/json") {
+			format = JSONListing
+		}
+	}
+
+	var body []byte
+	ctype := "text/html; charset=utf-8"
+	if format == JSONListing {
+		ctype = "application/json"
+		body, err = json.Marshal(struct {
+			Path    string         `json:"path"`
+			Entries []listingEntry `json:"entries"`
+		}{req.RequestLine.Path, entries})
+		if err != nil {
+			writeFSError(w, err)
+			return
+		}
+	} else {
+		body = htmlListing(req.RequestLine.Path, entries)
+	}
+
+	w.WriteStatusLine(response.StatusOK)
+	w.Header.Set("Content-Type", ctype)
+	w.Header.Set("Content-Length", fmt.Sprintf("%d", len(body)))
+	w.WriteHeaders(w.Header)
+	if req.RequestLine.Method != "HEAD" {
+		w.WriteBody(body)
+	}
+}
+
+func htmlListing(dir string, entries []listingEntry) []byte {
+	var b strings.Builder
+	title := html.EscapeString("Index of " + dir)
+	fmt.Fprintf(&b, "<!DOCTYPE html>\n<html>\n<head><meta charset=\"utf-8\"><title>%s</title></head>\n<body>\n<h1>%s</h1>\n<ul>\n", title, title)
+	if dir != "/" {
+		b.WriteString("<li><a href=\"../\">../</a></li>\n")
+	}
+	for _, e := range entries {
+		name := e.Name
+		if e.IsDir {
+			name += "/"
+		}
+		// "./" keeps a name containing a colon from reading as a scheme
+		href := "./" + url.PathEscape(e.Name)
+		if e.IsDir {
+			href += "/"
+		}
+		fmt.Fprintf(&b, "<li><a href=\"%s\">%s</a></li>\n", html.EscapeString(href), html.EscapeString(name))
+	}
+	b.WriteString("</ul>\n</body>\n</html>\n")
+	return []byte(b.String())
+}
@@ -0,0 +1,101 @@
+package fileserver
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sunilpar/My-Own-Http-Server/internal/httpcache"
+	"github.com/sunilpar/My-Own-Http-Server/internal/request"
+	"github.com/sunilpar/My-Own-Http-Server/internal/response"
+)
+
+// maxRanges bounds how many ranges one request may ask for.
+const maxRanges = 64
+
+var (
+	errInvalidRange       = errors.New("invalid range")
+	errUnsatisfiableRange = errors.New("unsatisfiable range")
+)
+
+type byteRange struct {
+	start, length int64
+}
+
+func (r byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.start+r.length-1, size)
+}
+
+// parseRange parses a Range header against content of the given size.
+// Ranges starting past the end are dropped; if none are left the result is
+// errUnsatisfiableRange. A malformed header gives errInvalidRange and should
+// be ignored, as RFC 9110 allows.
+func parseRange(s string, size int64) ([]byteRange, error) {
+	spec, ok := strings.CutPrefix(s, "bytes=")
+	if !ok {
+		return nil, errInvalidRange
+	}
+	parts := strings.Split(spec, ",")
+	if len(parts) > maxRanges {
+		return nil, errInvalidRange
+	}
+	var ranges []byteRange
+	for _, part := range parts {
+		part = strings.TrimSpace(part)
+		first, last, ok := strings.Cut(part, "-")
+		if !ok {
+			return nil, errInvalidRange
+		}
+		if first == "" {
+			// suffix range: the last n bytes
+			n, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || n < 0 {
+				return nil, errInvalidRange
+			}
+			if n == 0 || size == 0 {
+				continue
+			}
+			n = min(n, size)
+			ranges = append(ranges, byteRange{start: size - n, length: n})
+			continue
+		}
+		start, err := strconv.ParseInt(first, 10, 64)
+		if err != nil || start < 0 {
+			return nil, errInvalidRange
+		}
+		end := size - 1
+		if last != "" {
+			end, err = strconv.ParseInt(last, 10, 64)
+			if err != nil || end < start {
+				return nil, errInvalidRange
+			}
+			end = min(end, size-1)
+		}
+		if start >= size {
+			continue
+		}
+		ranges = append(ranges, byteRange{start: start, length: end - start + 1})
+	}
+	if len(ranges) == 0 {
+		return nil, errUnsatisfiableRange
+	}
+	return ranges, nil
+}
+
+// ifRangeMatches reports whether an If-Range precondition, if any, allows
+// the Range header to be used. Only a strong entity tag or an exact
+// modification date match.
+func ifRangeMatches(req *request.Request, w *response.Writer, modtime time.Time) bool {
+	cond := req.Headers.Get("If-Range")
+	if cond == "" {
+		return true
+	}
+	if strings.HasPrefix(cond, `"`) || strings.HasPrefix(cond, "W/") {
+		etag := w.Header.Get("ETag")
+		return etag != "" && !strings.HasPrefix(cond, "W/") && cond == etag
+	}
+	t, err := time.Parse(httpcache.TimeFormat, cond)
+	return err == nil && !modtime.IsZero() && t.Equal(modtime.UTC().Truncate(time.Second))
+}
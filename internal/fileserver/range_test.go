@@ -0,0 +1,155 @@
+package fileserver
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunilpar/My-Own-Http-Server/internal/httpcache"
+	"github.com/sunilpar/My-Own-Http-Server/internal/testutil"
+)
+
+func TestParseRange(t *testing.T) {
+	cases := []struct {
+		header string
+		want   []byteRange
+		err    error
+	}{
+		{"bytes=0-4", []byteRange{{0, 5}}, nil},
+		{"bytes=5-", []byteRange{{5, 5}}, nil},
+		{"bytes=-3", []byteRange{{7, 3}}, nil},
+		{"bytes=-30", []byteRange{{0, 10}}, nil},
+		{"bytes=8-100", []byteRange{{8, 2}}, nil},
+		{"bytes=0-0, 2-3", []byteRange{{0, 1}, {2, 2}}, nil},
+		{"bytes=20-30, 1-1", []byteRange{{1, 1}}, nil},
+		{"bytes=10-", nil, errUnsatisfiableRange},
+		{"bytes=-0", nil, errUnsatisfiableRange},
+		{"items=0-1", nil, errInvalidRange},
+		{"bytes=4-2", nil, errInvalidRange},
+		{"bytes=a-b", nil, errInvalidRange},
+		{"bytes=5", nil, errInvalidRange},
+		{"bytes=" + strings.Repeat("0-0,", maxRanges) + "0-0", nil, errInvalidRange},
+	}
+	for _, c := range cases {
+		got, err := parseRange(c.header, 10)
+		assert.Equal(t, c.err, err, c.header)
+		assert.Equal(t, c.want, got, c.header)
+	}
+}
+
+var modtime = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func rangeFS() fstest.MapFS {
+	return fstest.MapFS{"digits.txt": {Data: []byte("0123456789"), ModTime: modtime}}
+}
+
+func TestSingleRange(t *testing.T) {
+	f := New(rangeFS(), Options{})
+
+	resp := testutil.Serve(t, f.ServeRequest, "GET /digits.txt HTTP/1.1\r\nHost: a\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 200 OK", testutil.Status(resp))
+	assert.Contains(t, resp, "accept-ranges: bytes\r\n")
+
+	resp = testutil.Serve(t, f.ServeRequest, "GET /digits.txt HTTP/1.1\r\nHost: a\r\nRange: bytes=2-5\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 206 Partial Content", testutil.Status(resp))
+	assert.Contains(t, resp, "content-range: bytes 2-5/10\r\n")
+	assert.Contains(t, resp, "content-length: 4\r\n")
+	assert.Equal(t, "2345", testutil.Body(resp))
+
+	resp = testutil.Serve(t, f.ServeRequest, "GET /digits.txt HTTP/1.1\r\nHost: a\r\nRange: bytes=-2\r\n\r\n")
+	assert.Equal(t, "89", testutil.Body(resp))
+
+	// malformed ranges are ignored
+	resp = testutil.Serve(t, f.ServeRequest, "GET /digits.txt HTTP/1.1\r\nHost: a\r\nRange: bytes=x\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 200 OK", testutil.Status(resp))
+	assert.Equal(t, "0123456789", testutil.Body(resp))
+
+	// overlapping ranges adding up to more than the file get the whole file
+	resp = testutil.Serve(t, f.ServeRequest, "GET /digits.txt HTTP/1.1\r\nHost: a\r\nRange: bytes=0-8,1-9\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 200 OK", testutil.Status(resp))
+}
+
+func TestUnsatisfiableRange(t *testing.T) {
+	f := New(rangeFS(), Options{})
+	resp := testutil.Serve(t, f.ServeRequest, "GET /digits.txt HTTP/1.1\r\nHost: a\r\nRange: bytes=10-20\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 416 Range Not Satisfiable", testutil.Status(resp))
+	assert.Contains(t, resp, "content-range: bytes */10\r\n")
+}
+
+func TestMultipleRanges(t *testing.T) {
+	f := New(rangeFS(), Options{})
+	resp := testutil.Serve(t, f.ServeRequest, "GET /digits.txt HTTP/1.1\r\nHost: a\r\nRange: bytes=0-1, 7-\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 206 Partial Content", testutil.Status(resp))
+
+	headers, b, _ := strings.Cut(resp, "\r\n\r\n")
+	var ctype string
+	for _, line := range strings.Split(headers, "\r\n") {
+		if v, ok := strings.CutPrefix(line, "content-type: "); ok {
+			ctype = v
+		}
+	}
+	mediaType, params, err := mime.ParseMediaType(ctype)
+	require.NoError(t, err)
+	assert.Equal(t, "multipart/byteranges", mediaType)
+	assert.Contains(t, headers+"\r\n", "content-length: "+strconv.Itoa(len(b))+"\r\n")
+
+	mr := multipart.NewReader(strings.NewReader(b), params["boundary"])
+	var parts []string
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		data, _ := io.ReadAll(p)
+		parts = append(parts, p.Header.Get("Content-Range")+" "+string(data))
+		assert.Equal(t, "text/plain; charset=utf-8", p.Header.Get("Content-Type"))
+	}
+	assert.Equal(t, []string{"bytes 0-1/10 01", "bytes 7-9/10 789"}, parts)
+}
+
+func TestIfRange(t *testing.T) {
+	f := New(rangeFS(), Options{})
+	date := modtime.Format(httpcache.TimeFormat)
+
+	resp := testutil.Serve(t, f.ServeRequest, "GET /digits.txt HTTP/1.1\r\nHost: a\r\nRange: bytes=0-0\r\nIf-Range: "+date+"\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 206 Partial Content", testutil.Status(resp))
+
+	old := modtime.Add(-time.Hour).Format(httpcache.TimeFormat)
+	resp = testutil.Serve(t, f.ServeRequest, "GET /digits.txt HTTP/1.1\r\nHost: a\r\nRange: bytes=0-0\r\nIf-Range: "+old+"\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 200 OK", testutil.Status(resp))
+
+	resp = testutil.Serve(t, f.ServeRequest, "GET /digits.txt HTTP/1.1\r\nHost: a\r\nRange: bytes=0-0\r\nIf-Range: \"abc\"\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 200 OK", testutil.Status(resp))
+}
+
+func TestConditionalGet(t *testing.T) {
+	f := New(rangeFS(), Options{})
+
+	resp := testutil.Serve(t, f.ServeRequest, "GET /digits.txt HTTP/1.1\r\nHost: a\r\n\r\n")
+	assert.Contains(t, resp, "last-modified: Wed, 01 May 2024 12:00:00 GMT\r\n")
+	etag := httpcache.FileETag(modtime, 10)
+	assert.Contains(t, resp, "etag: "+etag+"\r\n")
+
+	resp = testutil.Serve(t, f.ServeRequest, "GET /digits.txt HTTP/1.1\r\nHost: a\r\nIf-None-Match: "+etag+"\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 304 Not Modified", testutil.Status(resp))
+	assert.Empty(t, testutil.Body(resp))
+
+	resp = testutil.Serve(t, f.ServeRequest, "GET /digits.txt HTTP/1.1\r\nHost: a\r\nIf-Modified-Since: Wed, 01 May 2024 12:00:00 GMT\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 304 Not Modified", testutil.Status(resp))
+
+	resp = testutil.Serve(t, f.ServeRequest, "GET /digits.txt HTTP/1.1\r\nHost: a\r\nIf-Match: \"other\"\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 412 Precondition Failed", testutil.Status(resp))
+
+	// a strong ETag now satisfies If-Range
+	resp = testutil.Serve(t, f.ServeRequest, "GET /digits.txt HTTP/1.1\r\nHost: a\r\nRange: bytes=0-0\r\nIf-Range: "+etag+"\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 206 Partial Content", testutil.Status(resp))
+}
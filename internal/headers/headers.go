@@ -53,15 +53,47 @@ func (h Headers) Parse(data []byte) (n int, done bool, err error) {
 
 	return crlfIndex + 2, false, nil
 }
+
+// Get returns the value of key, or its first value if it was given more
+// than one with Add.
 func (h Headers) Get(key string) string {
 	key = strings.ToLower(key)
 	for k, v := range h {
 		if strings.ToLower(k) == key {
-			return v
+			first, _, _ := strings.Cut(v, valueSep)
+			return first
 		}
 	}
 	return ""
 }
+
+// valueSep separates the values Add stores under one key. A field value
+// can never contain it, since it would end the field line.
+const valueSep = "\n"
+
+// Add appends a value to key. Unlike a second Set, or a repeated field in
+// Parse, the values are not joined with commas: each is written on its own
+// field line, which Set-Cookie requires.
+func (h Headers) Add(key, value string) {
+	if h == nil {
+		return
+	}
+	normalizedKey := strings.ToLower(strings.TrimSpace(key))
+	if existing, ok := h[normalizedKey]; ok {
+		h[normalizedKey] = existing + valueSep + strings.TrimSpace(value)
+		return
+	}
+	h[normalizedKey] = strings.TrimSpace(value)
+}
+
+// Values returns every value of key, one per field line.
+func (h Headers) Values(key string) []string {
+	v, ok := h[strings.ToLower(strings.TrimSpace(key))]
+	if !ok {
+		return nil
+	}
+	return strings.Split(v, valueSep)
+}
 func (h Headers) Set(key, value string) {
 	if h == nil {
 		return
@@ -101,3 +101,15 @@ func TestMultipleHeaderValuesAreCombined(t *testing.T) {
 	assert.Equal(t, "lane-loves-go, prime-loves-zig, tj-loves-ocaml", headers["set-person"])
 	assert.Equal(t, 28, n3)
 }
+
+func TestAddKeepsValuesApart(t *testing.T) {
+	headers := NewHeaders()
+	headers.Add("Set-Cookie", "a=1; Path=/")
+	headers.Add("set-cookie", "b=2, c")
+	assert.Equal(t, []string{"a=1; Path=/", "b=2, c"}, headers.Values("Set-Cookie"))
+	assert.Equal(t, "a=1; Path=/", headers.Get("Set-Cookie"))
+
+	headers.Set("Set-Cookie", "d=4")
+	assert.Equal(t, []string{"d=4"}, headers.Values("Set-Cookie"))
+	assert.Nil(t, headers.Values("Missing"))
+}
@@ -0,0 +1,136 @@
+// Package httpcache implements entity tags, conditional requests and
+// Cache-Control policies.
+package httpcache
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"time"
+
+	"github.com/sunilpar/My-Own-Http-Server/internal/request"
+	"github.com/sunilpar/My-Own-Http-Server/internal/response"
+	"github.com/sunilpar/My-Own-Http-Server/internal/server"
+)
+
+// TimeFormat is the IMF-fixdate format of HTTP dates.
+const TimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// FileETag returns a strong entity tag for a file, derived from its
+// modification time and size.
+func FileETag(modtime time.Time, size int64) string {
+	return fmt.Sprintf(`"%x-%x"`, modtime.UnixNano(), size)
+}
+
+// BodyETag returns a weak entity tag hashed from a generated body. It is
+// weak because the same content may be encoded differently on the wire.
+func BodyETag(body []byte) string {
+	h := fnv.New64a()
+	h.Write(body)
+	return fmt.Sprintf(`W/"%x"`, h.Sum64())
+}
+
+// CacheControl returns middleware setting the Cache-Control header to
+// policy, e.g. "public, max-age=3600" or "no-store", for every response of
+// the routes it wraps. A handler can still replace it.
+func CacheControl(policy string) server.Middleware {
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			w.Header.Set("Cache-Control", policy)
+			next(w, req)
+		}
+	}
+}
+
+// CheckPreconditions evaluates If-Match, If-Unmodified-Since, If-None-Match
+// and If-Modified-Since, in the order RFC 9110 gives, against the ETag
+// already set on w and modtime, which may be zero if unknown. If the
+// request should not be served it writes the 304 or 412 response and
+// returns true.
+func CheckPreconditions(w *response.Writer, req *request.Request, modtime time.Time) bool {
+	method := req.RequestLine.Method
+	etag := w.Header.Get("ETag")
+	modtime = modtime.UTC().Truncate(time.Second)
+
+	if im := req.Headers.Get("If-Match"); im != "" {
+		if !matchETag(im, etag, false) {
+			response.WriteText(w, response.StatusPreconditionFailed, "Precondition Failed")
+			return true
+		}
+	} else if ius := req.Headers.Get("If-Unmodified-Since"); ius != "" && !modtime.IsZero() {
+		if t, err := time.Parse(TimeFormat, ius); err == nil && modtime.After(t) {
+			response.WriteText(w, response.StatusPreconditionFailed, "Precondition Failed")
+			return true
+		}
+	}
+
+	if inm := req.Headers.Get("If-None-Match"); inm != "" {
+		if matchETag(inm, etag, true) {
+			if method == "GET" || method == "HEAD" {
+				writeNotModified(w)
+			} else {
+				response.WriteText(w, response.StatusPreconditionFailed, "Precondition Failed")
+			}
+			return true
+		}
+	} else if ims := req.Headers.Get("If-Modified-Since"); ims != "" && !modtime.IsZero() && (method == "GET" || method == "HEAD") {
+		if t, err := time.Parse(TimeFormat, ims); err == nil && !modtime.After(t) {
+			writeNotModified(w)
+			return true
+		}
+	}
+	return false
+}
+
+// ServeBytes sends a generated body with a weak entity tag, answering a
+// matching conditional request with 304 instead.
+func ServeBytes(w *response.Writer, req *request.Request, status response.StatusCode, ctype string, body []byte) {
+	w.Header.Set("ETag", BodyETag(body))
+	if status == response.StatusOK && CheckPreconditions(w, req, time.Time{}) {
+		return
+	}
+	w.WriteStatusLine(status)
+	w.Header.Set("Content-Type", ctype)
+	w.Header.Set("Content-Length", fmt.Sprintf("%d", len(body)))
+	w.WriteHeaders(w.Header)
+	if req.RequestLine.Method != "HEAD" {
+		w.WriteBody(body)
+	}
+}
+
+// matchETag reports whether etag is in the comma-separated list, or the
+// list is "*" and there is a current representation. If-None-Match uses
+// weak comparison; If-Match uses strong comparison, so weak tags never
+// match there.
+func matchETag(list, etag string, weak bool) bool {
+	if etag == "" {
+		return false
+	}
+	if strings.TrimSpace(list) == "*" {
+		return true
+	}
+	if !weak && strings.HasPrefix(etag, "W/") {
+		return false
+	}
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if weak {
+			if strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		} else if candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// writeNotModified sends a 304 carrying the validators and cache headers
+// already set, but none of the headers describing a body.
+func writeNotModified(w *response.Writer) {
+	for _, h := range []string{"Content-Type", "Content-Length", "Content-Range", "Transfer-Encoding"} {
+		w.Header.Del(h)
+	}
+	w.WriteStatusLine(response.StatusNotModified)
+	w.WriteHeaders(w.Header)
+}
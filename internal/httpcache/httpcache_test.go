@@ -0,0 +1,90 @@
+package httpcache
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sunilpar/My-Own-Http-Server/internal/request"
+	"github.com/sunilpar/My-Own-Http-Server/internal/response"
+	"github.com/sunilpar/My-Own-Http-Server/internal/testutil"
+)
+
+var modtime = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+// resource answers with a fixed ETag and modification time unless a
+// precondition stops it.
+func resource(w *response.Writer, req *request.Request) {
+	w.Header.Set("ETag", `"v1"`)
+	if CheckPreconditions(w, req, modtime) {
+		return
+	}
+	w.WriteStatusLine(response.StatusOK)
+	w.Header.Set("Content-Length", "2")
+	w.WriteHeaders(w.Header)
+	w.WriteBody([]byte("ok"))
+}
+
+func TestCheckPreconditions(t *testing.T) {
+	before := modtime.Add(-time.Hour).Format(TimeFormat)
+	after := modtime.Add(time.Hour).Format(TimeFormat)
+	cases := []struct {
+		method, header, want string
+	}{
+		{"GET", "", "200"},
+		{"GET", `If-None-Match: "v1"`, "304"},
+		{"GET", `If-None-Match: W/"v1"`, "304"},
+		{"GET", `If-None-Match: "v0", "v1"`, "304"},
+		{"GET", `If-None-Match: *`, "304"},
+		{"GET", `If-None-Match: "v2"`, "200"},
+		{"PUT", `If-None-Match: *`, "412"},
+		{"GET", "If-Modified-Since: " + modtime.Format(TimeFormat), "304"},
+		{"GET", "If-Modified-Since: " + before, "200"},
+		// If-None-Match takes precedence over If-Modified-Since
+		{"GET", "If-None-Match: \"v2\"\r\nIf-Modified-Since: " + after, "200"},
+		{"PUT", "If-Modified-Since: " + after, "200"},
+		{"PUT", `If-Match: "v1"`, "200"},
+		{"PUT", `If-Match: W/"v1"`, "412"},
+		{"PUT", `If-Match: "v2"`, "412"},
+		{"PUT", "If-Unmodified-Since: " + after, "200"},
+		{"PUT", "If-Unmodified-Since: " + before, "412"},
+		// If-Match takes precedence over If-Unmodified-Since
+		{"PUT", "If-Match: \"v1\"\r\nIf-Unmodified-Since: " + before, "200"},
+	}
+	for _, c := range cases {
+		raw := c.method + " / HTTP/1.1\r\nHost: a\r\nContent-Length: 0\r\n"
+		if c.header != "" {
+			raw += c.header + "\r\n"
+		}
+		resp := testutil.Serve(t, resource, raw+"\r\n")
+		assert.True(t, strings.HasPrefix(testutil.Status(resp), "HTTP/1.1 "+c.want), "%s %s: %s", c.method, c.header, testutil.Status(resp))
+	}
+}
+
+func TestNotModifiedKeepsValidators(t *testing.T) {
+	h := CacheControl("public, max-age=60")(resource)
+	resp := testutil.Serve(t, h, "GET / HTTP/1.1\r\nHost: a\r\nIf-None-Match: \"v1\"\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 304 Not Modified", testutil.Status(resp))
+	assert.Contains(t, resp, "etag: \"v1\"\r\n")
+	assert.Contains(t, resp, "cache-control: public, max-age=60\r\n")
+	assert.NotContains(t, resp, "content-length")
+	assert.True(t, strings.HasSuffix(resp, "\r\n\r\n"))
+}
+
+func TestServeBytes(t *testing.T) {
+	page := func(w *response.Writer, req *request.Request) {
+		ServeBytes(w, req, response.StatusOK, "text/html", []byte("<p>hi</p>"))
+	}
+	etag := BodyETag([]byte("<p>hi</p>"))
+	assert.True(t, strings.HasPrefix(etag, `W/"`))
+	assert.NotEqual(t, etag, BodyETag([]byte("<p>bye</p>")))
+
+	resp := testutil.Serve(t, page, "GET / HTTP/1.1\r\nHost: a\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 200 OK", testutil.Status(resp))
+	assert.Contains(t, resp, "etag: "+etag+"\r\n")
+
+	resp = testutil.Serve(t, page, "GET / HTTP/1.1\r\nHost: a\r\nIf-None-Match: "+etag+"\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 304 Not Modified", testutil.Status(resp))
+}
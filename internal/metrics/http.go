@@ -0,0 +1,162 @@
+package metrics
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sunilpar/My-Own-Http-Server/internal/request"
+	"github.com/sunilpar/My-Own-Http-Server/internal/response"
+	"github.com/sunilpar/My-Own-Http-Server/internal/server"
+)
+
+// Handler serves the registry in the text exposition format.
+func (r *Registry) Handler(w *response.Writer, req *request.Request) {
+	var buf bytes.Buffer
+	r.WriteText(&buf)
+	w.WriteStatusLine(response.StatusOK)
+	w.Header.Set("Content-Type", ContentType)
+	w.Header.Set("Content-Length", fmt.Sprintf("%d", buf.Len()))
+	w.WriteHeaders(w.Header)
+	w.WriteBody(buf.Bytes())
+}
+
+// ServerMetrics are the built-in metrics for the HTTP server. Middleware
+// records per-request metrics; Options hooks the connection and parse error
+// metrics into the server.
+type ServerMetrics struct {
+	Requests         *CounterVec
+	Duration         *HistogramVec
+	RequestBytes     *HistogramVec
+	ResponseBytes    *HistogramVec
+	ParseErrors      *CounterVec
+	SlowClients      *CounterVec
+	ActiveConns      Gauge
+	IdleConns        Gauge
+	UpstreamDuration *HistogramVec
+
+	mu    sync.Mutex
+	conns map[net.Conn]server.ConnState
+}
+
+func NewServerMetrics(reg *Registry) *ServerMetrics {
+	return &ServerMetrics{
+		Requests: reg.NewCounterVec("http_requests_total",
+			"Requests handled, by method, route and status code.", "method", "route", "code"),
+		Duration: reg.NewHistogramVec("http_request_duration_seconds",
+			"Time from the start of the request until the handler returned.", DefBuckets, "method", "route"),
+		RequestBytes: reg.NewHistogramVec("http_request_size_bytes",
+			"Size of request bodies.", ByteBuckets, "method", "route"),
+		ResponseBytes: reg.NewHistogramVec("http_response_size_bytes",
+			"Size of response bodies.", ByteBuckets, "method", "route"),
+		ParseErrors: reg.NewCounterVec("http_parse_errors_total",
+			"Requests rejected before reaching a handler, by error type.", "type"),
+		SlowClients: reg.NewCounterVec("http_slow_clients_total",
+			"Connections closed for falling below the minimum data rate, by direction.", "direction"),
+		ActiveConns: reg.NewGauge("http_connections_active",
+			"Connections currently handling a request."),
+		IdleConns: reg.NewGauge("http_connections_idle",
+			"Open connections waiting for a request."),
+		UpstreamDuration: reg.NewHistogramVec("http_upstream_duration_seconds",
+			"Latency of requests made to upstream servers by the proxy.", DefBuckets, "upstream", "code"),
+		conns: make(map[net.Conn]server.ConnState),
+	}
+}
+
+func (m *ServerMetrics) Options() []server.Option {
+	return []server.Option{
+		server.WithConnStateHook(m.ConnState),
+		server.WithParseErrorHook(m.ParseError),
+		server.WithSlowClientHook(m.SlowClient),
+	}
+}
+
+func (m *ServerMetrics) Middleware(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		start := req.StartTime
+		if start.IsZero() {
+			start = time.Now()
+		}
+		var body *countingReader
+		if req.BodyReader != nil {
+			// a streamed body is measured as the handler reads it
+			body = &countingReader{r: req.BodyReader}
+			req.BodyReader = body
+		}
+		next(w, req)
+
+		route := req.Pattern
+		if route == "" {
+			route = "unmatched"
+		}
+		requestBytes := int64(len(req.Body))
+		if body != nil {
+			requestBytes = body.n
+		}
+		method := req.RequestLine.Method
+		m.Requests.With(method, route, strconv.Itoa(int(w.Status()))).Inc()
+		m.Duration.With(method, route).Observe(time.Since(start).Seconds())
+		m.RequestBytes.With(method, route).Observe(float64(requestBytes))
+		m.ResponseBytes.With(method, route).Observe(float64(w.BytesWritten()))
+	}
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (m *ServerMetrics) ConnState(conn net.Conn, state server.ConnState) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if prev, ok := m.conns[conn]; ok {
+		m.gaugeFor(prev).Dec()
+	}
+	if state == server.StateClosed {
+		delete(m.conns, conn)
+		return
+	}
+	m.conns[conn] = state
+	m.gaugeFor(state).Inc()
+}
+
+// gaugeFor maps a connection state onto the gauge counting it; new and idle
+// connections are both waiting for a request.
+func (m *ServerMetrics) gaugeFor(state server.ConnState) Gauge {
+	if state == server.StateActive {
+		return m.ActiveConns
+	}
+	return m.IdleConns
+}
+
+func (m *ServerMetrics) ParseError(err error) {
+	var perr *request.ParseError
+	switch {
+	case errors.As(err, &perr):
+		m.ParseErrors.With(perr.Kind).Inc()
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		m.ParseErrors.With("timeout").Inc()
+	default:
+		m.ParseErrors.With("read").Inc()
+	}
+}
+
+func (m *ServerMetrics) SlowClient(conn net.Conn, direction string) {
+	m.SlowClients.With(direction).Inc()
+}
+
+func (m *ServerMetrics) ObserveUpstream(upstream string, code int, d time.Duration) {
+	m.UpstreamDuration.With(upstream, strconv.Itoa(code)).Observe(d.Seconds())
+}
@@ -0,0 +1,141 @@
+package metrics
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunilpar/My-Own-Http-Server/internal/request"
+	"github.com/sunilpar/My-Own-Http-Server/internal/response"
+	"github.com/sunilpar/My-Own-Http-Server/internal/server"
+)
+
+func render(t *testing.T, reg *Registry) string {
+	t.Helper()
+	var buf bytes.Buffer
+	require.NoError(t, reg.WriteText(&buf))
+	return buf.String()
+}
+
+func TestCounterAndGaugeExposition(t *testing.T) {
+	reg := NewRegistry()
+	c := reg.NewCounterVec("requests_total", "Total requests.\nSecond line.", "method", "path")
+	c.With("GET", `/a"b\c`).Inc()
+	c.With("GET", `/a"b\c`).Add(2)
+	c.With("POST", "/").Inc()
+	g := reg.NewGauge("in_flight", "")
+	g.Inc()
+	g.Inc()
+	g.Dec()
+
+	assert.Equal(t, `# TYPE in_flight gauge
+in_flight 1
+# HELP requests_total Total requests.\nSecond line.
+# TYPE requests_total counter
+requests_total{method="GET",path="/a\"b\\c"} 3
+requests_total{method="POST",path="/"} 1
+`, render(t, reg))
+}
+
+func TestHistogramExposition(t *testing.T) {
+	reg := NewRegistry()
+	h := reg.NewHistogramVec("latency_seconds", "Latency.", []float64{0.1, 1}, "route")
+	h.With("/x").Observe(0.05)
+	h.With("/x").Observe(0.5)
+	h.With("/x").Observe(3)
+
+	assert.Equal(t, `# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{route="/x",le="0.1"} 1
+latency_seconds_bucket{route="/x",le="1"} 2
+latency_seconds_bucket{route="/x",le="+Inf"} 3
+latency_seconds_sum{route="/x"} 3.55
+latency_seconds_count{route="/x"} 3
+`, render(t, reg))
+}
+
+func TestRegistrationErrorsPanic(t *testing.T) {
+	reg := NewRegistry()
+	reg.NewCounter("a_total", "")
+	assert.Panics(t, func() { reg.NewCounter("a_total", "") })
+	assert.Panics(t, func() { reg.NewCounter("1bad", "") })
+	assert.Panics(t, func() { reg.NewHistogramVec("h", "", []float64{1}, "le") })
+	assert.Panics(t, func() { reg.NewHistogram("h2", "", []float64{2, 1}) })
+	assert.Panics(t, func() { reg.NewCounter("neg_total", "").Add(-1) })
+}
+
+func TestConnStateGauges(t *testing.T) {
+	reg := NewRegistry()
+	m := NewServerMetrics(reg)
+	a, _ := net.Pipe()
+	b, _ := net.Pipe()
+
+	m.ConnState(a, server.StateNew)
+	m.ConnState(b, server.StateNew)
+	m.ConnState(a, server.StateActive)
+	out := render(t, reg)
+	assert.Contains(t, out, "http_connections_active 1\n")
+	assert.Contains(t, out, "http_connections_idle 1\n")
+
+	m.ConnState(a, server.StateIdle)
+	m.ConnState(b, server.StateClosed)
+	out = render(t, reg)
+	assert.Contains(t, out, "http_connections_active 0\n")
+	assert.Contains(t, out, "http_connections_idle 1\n")
+}
+
+func TestRequestBytesCountsStreamedBody(t *testing.T) {
+	reg := NewRegistry()
+	m := NewServerMetrics(reg)
+	rr := request.NewReader(strings.NewReader("POST /upload HTTP/1.1\r\nHost: a\r\nContent-Length: 10\r\n\r\n0123456789"))
+	rr.StreamBody = func(*request.Request) bool { return true }
+	req, err := rr.ReadRequest()
+	require.NoError(t, err)
+	require.NotNil(t, req.BodyReader)
+
+	conn, client := net.Pipe()
+	defer client.Close()
+	go io.Copy(io.Discard, client)
+	m.Middleware(func(w *response.Writer, req *request.Request) {
+		io.Copy(io.Discard, req.BodyReader)
+		response.WriteText(w, response.StatusOK, "ok")
+	})(response.NewWriter(conn), req)
+	conn.Close()
+
+	assert.Contains(t, render(t, reg), `http_request_size_bytes_sum{method="POST",route="unmatched"} 10`+"\n")
+}
+
+func TestParseErrorsByType(t *testing.T) {
+	reg := NewRegistry()
+	m := NewServerMetrics(reg)
+
+	_, err := request.RequestFromReader(strings.NewReader("GET /a%zz HTTP/1.1\r\n\r\n"))
+	require.Error(t, err)
+	m.ParseError(err)
+	m.ParseError(fmt.Errorf("wrapped: %w", errors.New("connection reset")))
+
+	out := render(t, reg)
+	assert.Contains(t, out, `http_parse_errors_total{type="request_line"} 1`)
+	assert.Contains(t, out, `http_parse_errors_total{type="read"} 1`)
+}
+
+func TestSlowClientsByDirection(t *testing.T) {
+	reg := NewRegistry()
+	m := NewServerMetrics(reg)
+	conn, _ := net.Pipe()
+
+	m.SlowClient(conn, "read")
+	m.SlowClient(conn, "read")
+	m.SlowClient(conn, "write")
+
+	out := render(t, reg)
+	assert.Contains(t, out, `http_slow_clients_total{direction="read"} 2`)
+	assert.Contains(t, out, `http_slow_clients_total{direction="write"} 1`)
+}
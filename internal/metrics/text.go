@@ -0,0 +1,87 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// WriteText writes every metric family, sorted by name, in the Prometheus
+// text exposition format (version 0.0.4).
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	families := make([]*family, 0, len(r.families))
+	for _, f := range r.families {
+		families = append(families, f)
+	}
+	r.mu.Unlock()
+	sort.Slice(families, func(i, j int) bool { return families[i].name < families[j].name })
+
+	bw := bufio.NewWriter(w)
+	for _, f := range families {
+		if f.help != "" {
+			fmt.Fprintf(bw, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+		}
+		fmt.Fprintf(bw, "# TYPE %s %s\n", f.name, f.typ)
+		for _, s := range f.sortedSeries() {
+			s.mu.Lock()
+			if f.typ == histogramType {
+				writeHistogram(bw, f, s)
+			} else {
+				writeSample(bw, f.name, f.labels, s.labelValues, "", "", s.value)
+			}
+			s.mu.Unlock()
+		}
+	}
+	return bw.Flush()
+}
+
+func writeHistogram(w io.Writer, f *family, s *series) {
+	for i, upper := range f.buckets {
+		writeSample(w, f.name+"_bucket", f.labels, s.labelValues, "le", formatFloat(upper), float64(s.counts[i]))
+	}
+	writeSample(w, f.name+"_bucket", f.labels, s.labelValues, "le", "+Inf", float64(s.count))
+	writeSample(w, f.name+"_sum", f.labels, s.labelValues, "", "", s.sum)
+	writeSample(w, f.name+"_count", f.labels, s.labelValues, "", "", float64(s.count))
+}
+
+func writeSample(w io.Writer, name string, labels, values []string, extraLabel, extraValue string, v float64) {
+	io.WriteString(w, name)
+	if len(labels) > 0 || extraLabel != "" {
+		pairs := make([]string, 0, len(labels)+1)
+		for i, l := range labels {
+			pairs = append(pairs, fmt.Sprintf(`%s="%s"`, l, escapeLabel(values[i])))
+		}
+		if extraLabel != "" {
+			pairs = append(pairs, fmt.Sprintf(`%s="%s"`, extraLabel, extraValue))
+		}
+		fmt.Fprintf(w, "{%s}", strings.Join(pairs, ","))
+	}
+	fmt.Fprintf(w, " %s\n", formatFloat(v))
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
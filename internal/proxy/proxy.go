@@ -0,0 +1,339 @@
+// Package proxy forwards requests to an upstream HTTP server.
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sunilpar/My-Own-Http-Server/internal/headers"
+	"github.com/sunilpar/My-Own-Http-Server/internal/request"
+	"github.com/sunilpar/My-Own-Http-Server/internal/response"
+	"github.com/sunilpar/My-Own-Http-Server/internal/server"
+	"github.com/sunilpar/My-Own-Http-Server/internal/tracing"
+)
+
+// hopHeaders only concern one connection and are never forwarded, in
+// either direction, along with any header a Connection header names.
+var hopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Connection",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"TE",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// Rewrite replaces a leading Prefix of the request path with Replacement.
+// Prefix matches whole path segments only.
+type Rewrite struct {
+	Prefix      string
+	Replacement string
+}
+
+type Config struct {
+	// Target is the upstream, e.g. "https://httpbin.org/api". The rewritten
+	// request path is appended to its path and the query to its query.
+	Target *url.URL
+	// Rewrites are tried in order and the first matching one is applied.
+	Rewrites []Rewrite
+	// PreserveHost sends the client's Host header upstream instead of the
+	// target's.
+	PreserveHost bool
+	// Via is the name this proxy adds to Via headers; it defaults to the
+	// target host.
+	Via string
+	// Transport defaults to http.DefaultTransport.
+	Transport http.RoundTripper
+	// Tracer, if set, records a client span for every upstream request and
+	// passes the trace on.
+	Tracer *tracing.Tracer
+	// Observe, if set, is called with the upstream status, or 0 if there
+	// was no response, and how long the upstream took to answer.
+	Observe func(upstream string, code int, d time.Duration)
+}
+
+type Proxy struct {
+	cfg Config
+}
+
+func New(cfg Config) *Proxy {
+	if cfg.Target == nil || cfg.Target.Host == "" {
+		panic("proxy: target must be an absolute URL")
+	}
+	if cfg.Transport == nil {
+		cfg.Transport = http.DefaultTransport
+	}
+	if cfg.Via == "" {
+		cfg.Via = cfg.Target.Host
+	}
+	return &Proxy{cfg: cfg}
+}
+
+// ServeRequest forwards req and streams the upstream response back.
+func (p *Proxy) ServeRequest(w *response.Writer, req *request.Request) {
+	ctx := req.Context()
+	var span *tracing.Span
+	if p.cfg.Tracer != nil {
+		ctx, span = p.cfg.Tracer.Start(ctx, req.RequestLine.Method+" "+p.cfg.Target.Host, tracing.KindClient)
+		defer span.End()
+	}
+
+	out, err := p.outgoing(ctx, req)
+	if err != nil {
+		log.Printf("Error building proxy request: %v", err)
+		response.WriteText(w, response.StatusBadRequest, "Bad Request")
+		return
+	}
+	tracing.Inject(ctx, out.Header.Set)
+
+	start := time.Now()
+	resp, err := p.cfg.Transport.RoundTrip(out)
+	if err != nil {
+		p.observe(0, time.Since(start))
+		log.Printf("Error proxying to %s: %v", p.cfg.Target.Host, err)
+		if span != nil {
+			span.SetStatus(tracing.StatusError, err.Error())
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			response.WriteText(w, response.StatusGatewayTimeout, "Gateway Timeout")
+			return
+		}
+		response.WriteText(w, response.StatusBadGateway, "Bad Gateway")
+		return
+	}
+	defer resp.Body.Close()
+	p.observe(resp.StatusCode, time.Since(start))
+	if span != nil {
+		span.SetAttribute("http.response.status_code", resp.StatusCode)
+	}
+
+	p.copyResponse(w, req, resp)
+}
+
+func (p *Proxy) observe(code int, d time.Duration) {
+	if p.cfg.Observe != nil {
+		p.cfg.Observe(p.cfg.Target.Host, code, d)
+	}
+}
+
+// outgoing builds the upstream request. A streamed body is passed on as it
+// arrives, otherwise the one the parser read in full is sent.
+func (p *Proxy) outgoing(ctx context.Context, req *request.Request) (*http.Request, error) {
+	// the raw path may still hold dot-segments, repeated slashes and
+	// alternative escapes that would slip past the rewrite rules
+	path := joinPath(p.cfg.Target.Path, p.rewrite(request.CleanPath(req.RequestLine.Path)))
+	target := *p.cfg.Target
+	target.RawPath = escapePath(path)
+	target.Path = strings.ReplaceAll(path, "%2F", "/")
+	switch {
+	case target.RawQuery == "":
+		target.RawQuery = req.RequestLine.RawQuery
+	case req.RequestLine.RawQuery != "":
+		target.RawQuery += "&" + req.RequestLine.RawQuery
+	}
+
+	var body io.Reader
+	switch {
+	case req.BodyReader != nil:
+		body = req.BodyReader
+	case len(req.Body) > 0:
+		body = bytes.NewReader(req.Body)
+	}
+	out, err := http.NewRequestWithContext(ctx, req.RequestLine.Method, target.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if req.BodyReader != nil {
+		// a chunked body is sent on chunked
+		out.ContentLength = -1
+		if n, err := strconv.ParseInt(req.Headers.Get("Content-Length"), 10, 64); err == nil {
+			out.ContentLength = n
+		}
+	}
+
+	for key, value := range req.Headers {
+		out.Header.Set(key, value)
+	}
+	removeHopHeaders(out.Header)
+	// no 100 Continue is ever sent to the client, so one from upstream
+	// would be lost
+	out.Header.Del("Expect")
+	out.Header.Del("Content-Length")
+	out.Header.Del("Host")
+
+	host := requestHost(req)
+	if p.cfg.PreserveHost {
+		out.Host = host
+	}
+	if req.RemoteAddr != nil {
+		if ip, _, err := net.SplitHostPort(req.RemoteAddr.String()); err == nil {
+			appendHeader(out.Header, "X-Forwarded-For", ip)
+		}
+	}
+	out.Header.Set("X-Forwarded-Host", host)
+	proto := "http"
+	if req.TLS != nil {
+		proto = "https"
+	}
+	out.Header.Set("X-Forwarded-Proto", proto)
+	appendHeader(out.Header, "Via", "1.1 "+p.cfg.Via)
+	return out, nil
+}
+
+// rewrite applies the first matching rule to a decoded path. A prefix only
+// matches whole segments, so "/api" covers "/api/x" but not "/apix".
+func (p *Proxy) rewrite(path string) string {
+	for _, r := range p.cfg.Rewrites {
+		rest, ok := strings.CutPrefix(path, r.Prefix)
+		if ok && (rest == "" || rest[0] == '/' || strings.HasSuffix(r.Prefix, "/")) {
+			return r.Replacement + rest
+		}
+	}
+	return path
+}
+
+func (p *Proxy) copyResponse(w *response.Writer, req *request.Request, resp *http.Response) {
+	removeHopHeaders(resp.Header)
+	for key, values := range resp.Header {
+		// the response carries this server's request ID, not the one the
+		// upstream gave its own request
+		if strings.EqualFold(key, server.RequestIDHeader) {
+			continue
+		}
+		// each value keeps its own line; Set-Cookie cannot be joined
+		for _, v := range values {
+			w.Header.Add(key, v)
+		}
+	}
+	w.Header.Add("Via", "1.1 "+p.cfg.Via)
+
+	noBody := req.RequestLine.Method == "HEAD" || resp.StatusCode == 204 || resp.StatusCode == 304 ||
+		(resp.StatusCode >= 100 && resp.StatusCode < 200)
+	chunked := !noBody && resp.ContentLength < 0
+	if chunked {
+		w.Header.Del("Content-Length")
+		w.Header.Set("Transfer-Encoding", "chunked")
+		if len(resp.Trailer) > 0 {
+			names := make([]string, 0, len(resp.Trailer))
+			for name := range resp.Trailer {
+				names = append(names, name)
+			}
+			w.Header.Set("Trailer", strings.Join(names, ", "))
+		}
+	} else if !noBody {
+		w.Header.Set("Content-Length", fmt.Sprintf("%d", resp.ContentLength))
+	}
+
+	w.WriteStatusLine(response.StatusCode(resp.StatusCode))
+	w.WriteHeaders(w.Header)
+	if noBody {
+		return
+	}
+
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := resp.Body.Read(buf)
+		if n > 0 {
+			var werr error
+			if chunked {
+				_, werr = w.WriteChunkedBody(buf[:n])
+			} else {
+				_, werr = w.WriteBody(buf[:n])
+				if werr == nil {
+					werr = w.Flush()
+				}
+			}
+			if werr != nil {
+				log.Printf("Error writing proxied response: %v", werr)
+				return
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// leave the body short so the client sees it was cut off
+			log.Printf("Error reading from %s: %v", p.cfg.Target.Host, err)
+			return
+		}
+	}
+
+	if chunked {
+		w.WriteChunkedBodyDone()
+		trailer := headers.NewHeaders()
+		for key, values := range resp.Trailer {
+			for _, v := range values {
+				trailer.Add(key, v)
+			}
+		}
+		if err := w.WriteTrailers(trailer); err != nil {
+			log.Printf("Error writing trailers: %v", err)
+		}
+	}
+}
+
+func removeHopHeaders(h http.Header) {
+	for _, v := range h.Values("Connection") {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				h.Del(name)
+			}
+		}
+	}
+	for _, name := range hopHeaders {
+		h.Del(name)
+	}
+}
+
+func appendHeader(h http.Header, key, value string) {
+	if prior := h.Get(key); prior != "" {
+		value = prior + ", " + value
+	}
+	h.Set(key, value)
+}
+
+func joinPath(base, path string) string {
+	switch {
+	case base == "":
+		return path
+	case strings.HasSuffix(base, "/") && strings.HasPrefix(path, "/"):
+		return base + path[1:]
+	case !strings.HasSuffix(base, "/") && !strings.HasPrefix(path, "/") && path != "":
+		return base + "/" + path
+	}
+	return base + path
+}
+
+// escapePath escapes each segment of a decoded path. An encoded slash the
+// server let through stays encoded, so it is not turned into a separator.
+func escapePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, seg := range segments {
+		parts := strings.Split(seg, "%2F")
+		for j, part := range parts {
+			parts[j] = url.PathEscape(part)
+		}
+		segments[i] = strings.Join(parts, "%2F")
+	}
+	return strings.Join(segments, "/")
+}
+
+func requestHost(req *request.Request) string {
+	if req.RequestLine.Host != "" {
+		return req.RequestLine.Host
+	}
+	return req.Headers.Get("Host")
+}
@@ -0,0 +1,271 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunilpar/My-Own-Http-Server/internal/request"
+	"github.com/sunilpar/My-Own-Http-Server/internal/response"
+	"github.com/sunilpar/My-Own-Http-Server/internal/server"
+)
+
+func newProxy(t *testing.T, upstream string, cfg Config) *Proxy {
+	t.Helper()
+	target, err := url.Parse(upstream)
+	require.NoError(t, err)
+	cfg.Target = target
+	return New(cfg)
+}
+
+// do sends raw through p and parses what comes back. The response body is
+// read as it streams, so tests can watch it arrive.
+func do(t *testing.T, p *Proxy, raw string) *http.Response {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	return serve(t, p, req)
+}
+
+func serve(t *testing.T, p *Proxy, req *request.Request) *http.Response {
+	t.Helper()
+	req.RemoteAddr = &net.TCPAddr{IP: net.IPv4(192, 0, 2, 7), Port: 5555}
+
+	conn, client := net.Pipe()
+	t.Cleanup(func() { client.Close() })
+	go func() {
+		w := response.NewWriter(conn)
+		p.ServeRequest(w, req)
+		w.Finish()
+		conn.Close()
+	}()
+	resp, err := http.ReadResponse(bufio.NewReader(client), &http.Request{Method: req.RequestLine.Method})
+	require.NoError(t, err)
+	return resp
+}
+
+func TestForwardsRequest(t *testing.T) {
+	var got *http.Request
+	var gotBody []byte
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		gotBody, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer upstream.Close()
+
+	p := newProxy(t, upstream.URL+"/base?key=1", Config{
+		Rewrites: []Rewrite{{Prefix: "/api/v1", Replacement: "/v2"}, {Prefix: "/api", Replacement: ""}},
+		Via:      "edge",
+	})
+	resp := do(t, p, "PUT /api/v1/items/a%20b?x=y HTTP/1.1\r\nHost: example.com\r\n"+
+		"Content-Length: 5\r\nConnection: keep-alive, X-Secret\r\nX-Secret: s\r\nX-Kept: k\r\n"+
+		"X-Forwarded-For: 10.0.0.1\r\nVia: 1.0 outer\r\nProxy-Authorization: Basic x\r\n\r\nhello")
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, "1.1 edge", resp.Header.Get("Via"))
+
+	require.NotNil(t, got)
+	assert.Equal(t, "PUT", got.Method)
+	assert.Equal(t, "hello", string(gotBody))
+	assert.Equal(t, "/base/v2/items/a%20b", got.URL.EscapedPath())
+	assert.Equal(t, "key=1&x=y", got.URL.RawQuery)
+	assert.Equal(t, strings.TrimPrefix(upstream.URL, "http://"), got.Host)
+	assert.Equal(t, "k", got.Header.Get("X-Kept"))
+	assert.Empty(t, got.Header.Get("X-Secret"))
+	assert.Empty(t, got.Header.Get("Proxy-Authorization"))
+	assert.Equal(t, "10.0.0.1, 192.0.2.7", got.Header.Get("X-Forwarded-For"))
+	assert.Equal(t, "example.com", got.Header.Get("X-Forwarded-Host"))
+	assert.Equal(t, "http", got.Header.Get("X-Forwarded-Proto"))
+	assert.Equal(t, "1.0 outer, 1.1 edge", got.Header.Get("Via"))
+
+	p = newProxy(t, upstream.URL, Config{PreserveHost: true, Rewrites: []Rewrite{{Prefix: "/api", Replacement: ""}}})
+	do(t, p, "GET /api/other HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	assert.Equal(t, "example.com", got.Host)
+	assert.Equal(t, "/other", got.URL.Path)
+
+	// a prefix only matches whole segments
+	do(t, p, "GET /apix/other HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	assert.Equal(t, "/apix/other", got.URL.Path)
+	do(t, p, "GET /api HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	assert.Equal(t, "/", got.URL.Path)
+
+	// the rewrite sees the normalized path, and what is sent on is escaped
+	// again segment by segment
+	for _, target := range []string{"//api/other/%61%3b", "/api/x/../other/a;", "/%61pi/./other/a%3B"} {
+		do(t, p, "GET "+target+" HTTP/1.1\r\nHost: example.com\r\n\r\n")
+		assert.Equal(t, "/other/a%3B", got.URL.EscapedPath(), target)
+	}
+}
+
+func TestPassesResponseThrough(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/problem+json")
+		w.Header().Set("Connection", "X-Internal")
+		w.Header().Set("X-Internal", "secret")
+		w.Header().Add("X-Multi", "a")
+		w.Header().Add("X-Multi", "b")
+		w.Header().Add("Set-Cookie", "a=1; Expires=Wed, 21 Oct 2026 07:28:00 GMT")
+		w.Header().Add("Set-Cookie", "b=2")
+		w.Header().Set("X-Request-ID", "upstream-id")
+		w.Header().Set("Content-Length", "9")
+		w.WriteHeader(http.StatusTeapot)
+		io.WriteString(w, `{"a":"b"}`)
+	}))
+	defer upstream.Close()
+	p := newProxy(t, upstream.URL, Config{})
+
+	resp := do(t, p, "GET / HTTP/1.1\r\nHost: a\r\n\r\n")
+	assert.Equal(t, http.StatusTeapot, resp.StatusCode)
+	assert.Equal(t, "418 I'm a teapot", resp.Status)
+	assert.Equal(t, "application/problem+json", resp.Header.Get("Content-Type"))
+	assert.Equal(t, []string{"a", "b"}, resp.Header.Values("X-Multi"))
+	assert.Equal(t, []string{"a=1; Expires=Wed, 21 Oct 2026 07:28:00 GMT", "b=2"}, resp.Header.Values("Set-Cookie"))
+	assert.Empty(t, resp.Header.Get("X-Internal"))
+	assert.Empty(t, resp.Header.Get("X-Request-ID"))
+	assert.Equal(t, int64(9), resp.ContentLength)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, `{"a":"b"}`, string(body))
+
+	resp = do(t, p, "HEAD / HTTP/1.1\r\nHost: a\r\n\r\n")
+	assert.Equal(t, http.StatusTeapot, resp.StatusCode)
+}
+
+func TestKeepsServerRequestID(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Request-ID", "upstream-id")
+	}))
+	defer upstream.Close()
+	s, err := server.Serve(0, newProxy(t, upstream.URL, Config{}).ServeRequest)
+	require.NoError(t, err)
+	defer s.Close()
+
+	req, err := http.NewRequest("GET", "http://"+s.Addr().String()+"/", nil)
+	require.NoError(t, err)
+	req.Header.Set("X-Request-ID", "client-id")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, []string{"client-id"}, resp.Header.Values("X-Request-ID"))
+}
+
+func TestStreamsResponse(t *testing.T) {
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", "X-Checksum")
+		io.WriteString(w, "first\n")
+		w.(http.Flusher).Flush()
+		<-release
+		io.WriteString(w, "second\n")
+		w.Header().Set("X-Checksum", "abc")
+	}))
+	defer upstream.Close()
+	defer close(release)
+	p := newProxy(t, upstream.URL, Config{})
+
+	resp := do(t, p, "GET / HTTP/1.1\r\nHost: a\r\n\r\n")
+	assert.Equal(t, []string{"chunked"}, resp.TransferEncoding)
+	br := bufio.NewReader(resp.Body)
+	line, err := br.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "first\n", line)
+
+	release <- struct{}{}
+	rest, err := io.ReadAll(br)
+	require.NoError(t, err)
+	assert.Equal(t, "second\n", string(rest))
+	assert.Equal(t, "abc", resp.Trailer.Get("X-Checksum"))
+}
+
+func TestStreamsRequestBody(t *testing.T) {
+	// bigger than the transport's write buffer, so it has to go out before
+	// the rest of the body exists
+	first := strings.Repeat("a", 64<<10)
+	var gotFirst chan struct{}
+	var gotLength int64
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotLength = r.ContentLength
+		start := make([]byte, len(first))
+		io.ReadFull(r.Body, start)
+		close(gotFirst)
+		rest, _ := io.ReadAll(r.Body)
+		fmt.Fprintf(w, "%d|%s", len(start), rest)
+	}))
+	defer upstream.Close()
+	p := newProxy(t, upstream.URL, Config{})
+
+	for _, c := range []struct {
+		head, first, rest string
+		length            int64
+	}{
+		{fmt.Sprintf("Content-Length: %d\r\n", len(first)+5), first, " rest", int64(len(first) + 5)},
+		{"Transfer-Encoding: chunked\r\n", fmt.Sprintf("%x\r\n%s\r\n", len(first), first), "5\r\n rest\r\n0\r\n\r\n", -1},
+	} {
+		gotFirst = make(chan struct{})
+		streamed := make(chan bool, 1)
+		client, conn := io.Pipe()
+		go func() {
+			io.WriteString(conn, "POST / HTTP/1.1\r\nHost: a\r\n"+c.head+"\r\n"+c.first)
+			select {
+			case <-gotFirst:
+				streamed <- true
+			case <-time.After(5 * time.Second):
+				streamed <- false
+			}
+			io.WriteString(conn, c.rest)
+		}()
+		rr := request.NewReader(client)
+		rr.StreamBody = func(*request.Request) bool { return true }
+		req, err := rr.ReadRequest()
+		require.NoError(t, err)
+
+		resp := serve(t, p, req)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("%d| rest", len(first)), string(body))
+		assert.Equal(t, c.length, gotLength)
+		assert.True(t, <-streamed, "upstream only got the body once it was complete")
+	}
+}
+
+func TestUpstreamFailures(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := ln.Addr().String()
+	ln.Close()
+
+	var observed []int
+	p := newProxy(t, "http://"+addr, Config{Observe: func(upstream string, code int, d time.Duration) {
+		assert.Equal(t, addr, upstream)
+		observed = append(observed, code)
+	}})
+	resp := do(t, p, "GET / HTTP/1.1\r\nHost: a\r\n\r\n")
+	assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+	assert.Equal(t, []int{0}, observed)
+
+	// an upstream that dies mid-body leaves the response short
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "100")
+		io.WriteString(w, "partial")
+		w.(http.Flusher).Flush()
+		conn, _, _ := w.(http.Hijacker).Hijack()
+		conn.Close()
+	}))
+	defer upstream.Close()
+	p = newProxy(t, upstream.URL, Config{})
+	resp = do(t, p, "GET / HTTP/1.1\r\nHost: a\r\n\r\n")
+	body, err := io.ReadAll(resp.Body)
+	assert.Error(t, err)
+	assert.True(t, bytes.HasPrefix(body, []byte("partial")), fmt.Sprintf("%q", body))
+}
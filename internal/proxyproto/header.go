@@ -0,0 +1,250 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// v2Signature starts every version 2 header.
+var v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// v1MaxLength is the longest a version 1 line may be, CRLF included.
+const v1MaxLength = 107
+
+// TLV types defined by the specification.
+const (
+	TLVTypeALPN      byte = 0x01
+	TLVTypeAuthority byte = 0x02
+	TLVTypeCRC32C    byte = 0x03
+	TLVTypeNoop      byte = 0x04
+	TLVTypeUniqueID  byte = 0x05
+	TLVTypeSSL       byte = 0x20
+	TLVTypeNetNS     byte = 0x30
+)
+
+var ErrNoHeader = errors.New("proxyproto: connection did not start with a PROXY protocol header")
+
+type TLV struct {
+	Type  byte
+	Value []byte
+}
+
+// Header is a decoded PROXY protocol header. Source and Destination are nil
+// when the sender did not relay addresses, as with "PROXY UNKNOWN" or a
+// version 2 LOCAL command sent by a health check.
+type Header struct {
+	Version     int
+	Local       bool
+	Source      net.Addr
+	Destination net.Addr
+	TLVs        []TLV
+}
+
+// TLV returns the value of the first TLV of type t.
+func (h *Header) TLV(t byte) ([]byte, bool) {
+	for _, tlv := range h.TLVs {
+		if tlv.Type == t {
+			return tlv.Value, true
+		}
+	}
+	return nil, false
+}
+
+// ReadHeader reads a version 1 or 2 header from r, leaving anything after
+// it unread.
+func ReadHeader(r *bufio.Reader) (*Header, error) {
+	first, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	switch first[0] {
+	case 'P':
+		return readV1(r)
+	case '\r':
+		return readV2(r)
+	default:
+		return nil, ErrNoHeader
+	}
+}
+
+func readV1(r *bufio.Reader) (*Header, error) {
+	if prefix, err := r.Peek(6); err != nil || string(prefix) != "PROXY " {
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		return nil, ErrNoHeader
+	}
+	line, err := r.ReadSlice('\n')
+	if err != nil {
+		if err == bufio.ErrBufferFull {
+			err = fmt.Errorf("proxyproto: v1 header longer than %d bytes", v1MaxLength)
+		}
+		return nil, err
+	}
+	if len(line) > v1MaxLength || !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, fmt.Errorf("proxyproto: malformed v1 header %q", line)
+	}
+
+	h := &Header{Version: 1}
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return h, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("proxyproto: malformed v1 header %q", line)
+	}
+	src, err := parseV1Addr(fields[2], fields[4], fields[1] == "TCP4")
+	if err != nil {
+		return nil, err
+	}
+	dst, err := parseV1Addr(fields[3], fields[5], fields[1] == "TCP4")
+	if err != nil {
+		return nil, err
+	}
+	h.Source, h.Destination = src, dst
+	return h, nil
+}
+
+func parseV1Addr(ip, port string, v4 bool) (net.Addr, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil || addr.Is4() != v4 || addr.Zone() != "" {
+		return nil, fmt.Errorf("proxyproto: bad v1 address %q", ip)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil || (len(port) > 1 && port[0] == '0') {
+		return nil, fmt.Errorf("proxyproto: bad v1 port %q", port)
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, uint16(p))), nil
+}
+
+func readV2(r *bufio.Reader) (*Header, error) {
+	fixed, err := r.Peek(16)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if len(fixed) < len(v2Signature) || !bytes.Equal(fixed[:len(v2Signature)], v2Signature) {
+		return nil, ErrNoHeader
+	}
+	if len(fixed) < 16 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if fixed[12]>>4 != 2 {
+		return nil, fmt.Errorf("proxyproto: unsupported version %d", fixed[12]>>4)
+	}
+	command := fixed[12] & 0x0f
+	if command > 1 {
+		return nil, fmt.Errorf("proxyproto: unknown command %d", command)
+	}
+	family, transport := fixed[13]>>4, fixed[13]&0x0f
+
+	raw := make([]byte, 16+int(binary.BigEndian.Uint16(fixed[14:16])))
+	if _, err := io.ReadFull(r, raw); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	payload := raw[16:]
+
+	h := &Header{Version: 2, Local: command == 0}
+	var addrLen int
+	switch family {
+	case 0x0: // AF_UNSPEC
+	case 0x1:
+		addrLen = 12
+	case 0x2:
+		addrLen = 36
+	case 0x3:
+		addrLen = 216
+	default:
+		return nil, fmt.Errorf("proxyproto: unknown address family %d", family)
+	}
+	if len(payload) < addrLen {
+		return nil, fmt.Errorf("proxyproto: address block too short for family %d", family)
+	}
+	if !h.Local && family != 0 {
+		h.Source, h.Destination, err = parseV2Addrs(family, transport, payload[:addrLen])
+		if err != nil {
+			return nil, err
+		}
+	}
+	if family == 0 {
+		// the receiver must ignore everything after an unspecified family
+		return h, nil
+	}
+
+	rest := payload[addrLen:]
+	for len(rest) > 0 {
+		if len(rest) < 3 {
+			return nil, errors.New("proxyproto: truncated TLV")
+		}
+		n := int(binary.BigEndian.Uint16(rest[1:3]))
+		if len(rest) < 3+n {
+			return nil, errors.New("proxyproto: truncated TLV")
+		}
+		tlv := TLV{Type: rest[0], Value: rest[3 : 3+n]}
+		if tlv.Type == TLVTypeCRC32C {
+			if err := checkCRC32C(raw, tlv.Value); err != nil {
+				return nil, err
+			}
+		}
+		h.TLVs = append(h.TLVs, tlv)
+		rest = rest[3+n:]
+	}
+	return h, nil
+}
+
+func parseV2Addrs(family, transport byte, b []byte) (src, dst net.Addr, err error) {
+	if family == 0x3 {
+		name := func(b []byte) string {
+			if i := bytes.IndexByte(b, 0); i >= 0 {
+				b = b[:i]
+			}
+			return string(b)
+		}
+		return &net.UnixAddr{Name: name(b[:108]), Net: "unix"}, &net.UnixAddr{Name: name(b[108:216]), Net: "unix"}, nil
+	}
+
+	size := 4
+	if family == 0x2 {
+		size = 16
+	}
+	srcIP, _ := netip.AddrFromSlice(b[:size])
+	dstIP, _ := netip.AddrFromSlice(b[size : 2*size])
+	srcPort := binary.BigEndian.Uint16(b[2*size:])
+	dstPort := binary.BigEndian.Uint16(b[2*size+2:])
+	srcAP, dstAP := netip.AddrPortFrom(srcIP, srcPort), netip.AddrPortFrom(dstIP, dstPort)
+	switch transport {
+	case 0x1:
+		return net.TCPAddrFromAddrPort(srcAP), net.TCPAddrFromAddrPort(dstAP), nil
+	case 0x2:
+		return net.UDPAddrFromAddrPort(srcAP), net.UDPAddrFromAddrPort(dstAP), nil
+	default:
+		return nil, nil, fmt.Errorf("proxyproto: unknown transport protocol %d", transport)
+	}
+}
+
+// checkCRC32C verifies the checksum TLV, which covers the whole header with
+// the checksum itself zeroed.
+func checkCRC32C(raw, value []byte) error {
+	if len(value) != 4 {
+		return errors.New("proxyproto: bad CRC32C TLV length")
+	}
+	want := binary.BigEndian.Uint32(value)
+	copy(value, []byte{0, 0, 0, 0})
+	got := crc32.Checksum(raw, crc32.MakeTable(crc32.Castagnoli))
+	binary.BigEndian.PutUint32(value, want)
+	if got != want {
+		return errors.New("proxyproto: CRC32C checksum mismatch")
+	}
+	return nil
+}
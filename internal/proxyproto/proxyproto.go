@@ -0,0 +1,167 @@
+// Package proxyproto implements the receiving side of the HAProxy PROXY
+// protocol, versions 1 and 2, so that connections relayed by a layer 4 load
+// balancer report the real client address.
+package proxyproto
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Config struct {
+	// Trusted lists the networks allowed to send a header, normally just the
+	// load balancers. Connections from them must start with one; connections
+	// from anywhere else are passed through untouched, so a header they send
+	// reaches the HTTP parser and is rejected there.
+	Trusted []netip.Prefix
+	// HeaderTimeout bounds the wait for the header. It defaults to five
+	// seconds.
+	HeaderTimeout time.Duration
+}
+
+// ParseCIDRs parses a comma separated list of networks. A bare address is
+// taken as a network of one.
+func ParseCIDRs(s string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		if !strings.Contains(field, "/") {
+			addr, err := netip.ParseAddr(field)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(field)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, p.Masked())
+	}
+	return prefixes, nil
+}
+
+type listener struct {
+	net.Listener
+	cfg Config
+}
+
+// NewListener wraps ln so that connections from trusted networks have their
+// PROXY header read and their addresses replaced. The header is read on the
+// first call to Read, RemoteAddr or LocalAddr, not in Accept, so one slow
+// balancer connection cannot hold up the others.
+func NewListener(ln net.Listener, cfg Config) net.Listener {
+	if cfg.HeaderTimeout <= 0 {
+		cfg.HeaderTimeout = 5 * time.Second
+	}
+	return &listener{Listener: ln, cfg: cfg}
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if !l.trusted(conn.RemoteAddr()) {
+		return conn, nil
+	}
+	return &Conn{Conn: conn, timeout: l.cfg.HeaderTimeout}, nil
+}
+
+func (l *listener) trusted(addr net.Addr) bool {
+	tcp, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	ip, ok := netip.AddrFromSlice(tcp.IP)
+	if !ok {
+		return false
+	}
+	ip = ip.Unmap()
+	for _, p := range l.cfg.Trusted {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Conn is a connection from a trusted network.
+type Conn struct {
+	net.Conn
+	timeout time.Duration
+
+	once   sync.Once
+	br     *bufio.Reader
+	header *Header
+	err    error
+}
+
+func (c *Conn) readHeader() {
+	c.once.Do(func() {
+		c.br = bufio.NewReader(c.Conn)
+		c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+		c.header, c.err = ReadHeader(c.br)
+		c.Conn.SetReadDeadline(time.Time{})
+		if c.err != nil {
+			c.err = fmt.Errorf("PROXY header from %s: %w", c.Conn.RemoteAddr(), c.err)
+		}
+	})
+}
+
+// Header returns the decoded PROXY header, reading it if necessary.
+func (c *Conn) Header() (*Header, error) {
+	c.readHeader()
+	return c.header, c.err
+}
+
+func (c *Conn) Read(p []byte) (int, error) {
+	c.readHeader()
+	if c.err != nil {
+		return 0, c.err
+	}
+	if c.br.Buffered() > 0 {
+		return c.br.Read(p)
+	}
+	return c.Conn.Read(p)
+}
+
+// ReadFrom lets writes to the connection use sendfile; the header only
+// concerns the read side.
+func (c *Conn) ReadFrom(r io.Reader) (int64, error) {
+	if rf, ok := c.Conn.(io.ReaderFrom); ok {
+		return rf.ReadFrom(r)
+	}
+	return io.Copy(c.Conn, r)
+}
+
+// RemoteAddr returns the client address relayed in the header, or the
+// balancer's own address if there was none.
+func (c *Conn) RemoteAddr() net.Addr {
+	if h, err := c.Header(); err == nil && h.Source != nil {
+		return h.Source
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *Conn) LocalAddr() net.Addr {
+	if h, err := c.Header(); err == nil && h.Destination != nil {
+		return h.Destination
+	}
+	return c.Conn.LocalAddr()
+}
+
+// NetConn returns the underlying connection.
+func (c *Conn) NetConn() net.Conn {
+	return c.Conn
+}
@@ -0,0 +1,197 @@
+package proxyproto
+
+import (
+	"bufio"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func read(t *testing.T, raw string) (*Header, string, error) {
+	t.Helper()
+	r := bufio.NewReader(strings.NewReader(raw))
+	h, err := ReadHeader(r)
+	rest, _ := io.ReadAll(r)
+	return h, string(rest), err
+}
+
+func TestV1Header(t *testing.T) {
+	h, rest, err := read(t, "PROXY TCP4 203.0.113.7 10.0.0.1 51234 443\r\nGET / HTTP/1.1\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, 1, h.Version)
+	assert.Equal(t, "203.0.113.7:51234", h.Source.String())
+	assert.Equal(t, "10.0.0.1:443", h.Destination.String())
+	assert.Equal(t, "GET / HTTP/1.1\r\n", rest)
+
+	h, _, err = read(t, "PROXY TCP6 2001:db8::1 2001:db8::2 1 2\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, "[2001:db8::1]:1", h.Source.String())
+
+	h, _, err = read(t, "PROXY UNKNOWN ffff::1 ffff::2 1 2\r\n")
+	require.NoError(t, err)
+	assert.Nil(t, h.Source)
+}
+
+func TestV1HeaderErrors(t *testing.T) {
+	for _, raw := range []string{
+		"GET / HTTP/1.1\r\n",
+		"PROXY TCP4 2001:db8::1 10.0.0.1 1 2\r\n",
+		"PROXY TCP4 1.2.3.4 10.0.0.1 01 2\r\n",
+		"PROXY TCP4 1.2.3.4 10.0.0.1 1 70000\r\n",
+		"PROXY TCP4 1.2.3.4 10.0.0.1 1\r\n",
+		"PROXY TCP4 1.2.3.4 10.0.0.1 1 2\n",
+		"PROXY UNKNOWN " + strings.Repeat("x", 100) + "\r\n",
+	} {
+		_, _, err := read(t, raw)
+		assert.Error(t, err, raw)
+	}
+}
+
+// v2 builds a version 2 PROXY header for TCP over IPv4 with the given TLVs,
+// adding a CRC32C TLV when withCRC is set.
+func v2(command byte, tlvs []TLV, withCRC bool) []byte {
+	var payload []byte
+	payload = append(payload, 198, 51, 100, 9, 10, 0, 0, 1)
+	payload = binary.BigEndian.AppendUint16(payload, 40000)
+	payload = binary.BigEndian.AppendUint16(payload, 8443)
+	for _, tlv := range tlvs {
+		payload = append(payload, tlv.Type)
+		payload = binary.BigEndian.AppendUint16(payload, uint16(len(tlv.Value)))
+		payload = append(payload, tlv.Value...)
+	}
+	crcAt := -1
+	if withCRC {
+		payload = append(payload, TLVTypeCRC32C, 0, 4)
+		crcAt = len(payload)
+		payload = append(payload, 0, 0, 0, 0)
+	}
+
+	b := append([]byte{}, v2Signature...)
+	b = append(b, 0x20|command, 0x11)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(payload)))
+	b = append(b, payload...)
+	if crcAt >= 0 {
+		sum := crc32.Checksum(b, crc32.MakeTable(crc32.Castagnoli))
+		binary.BigEndian.PutUint32(b[16+crcAt:], sum)
+	}
+	return b
+}
+
+func TestV2Header(t *testing.T) {
+	raw := v2(1, []TLV{{Type: TLVTypeAuthority, Value: []byte("example.com")}}, true)
+	h, rest, err := read(t, string(raw)+"GET /")
+	require.NoError(t, err)
+	assert.Equal(t, 2, h.Version)
+	assert.False(t, h.Local)
+	assert.Equal(t, "198.51.100.9:40000", h.Source.String())
+	assert.Equal(t, "10.0.0.1:8443", h.Destination.String())
+	authority, ok := h.TLV(TLVTypeAuthority)
+	assert.True(t, ok)
+	assert.Equal(t, "example.com", string(authority))
+	assert.Equal(t, "GET /", rest)
+}
+
+func TestV2LocalKeepsAddresses(t *testing.T) {
+	h, _, err := read(t, string(v2(0, nil, false)))
+	require.NoError(t, err)
+	assert.True(t, h.Local)
+	assert.Nil(t, h.Source)
+}
+
+func TestV2Errors(t *testing.T) {
+	bad := v2(1, nil, true)
+	bad[len(bad)-1] ^= 0xff
+	_, _, err := read(t, string(bad))
+	assert.ErrorContains(t, err, "CRC32C")
+
+	truncated := v2(1, []TLV{{Type: TLVTypeNoop, Value: []byte("xx")}}, false)
+	binary.BigEndian.PutUint16(truncated[14:], binary.BigEndian.Uint16(truncated[14:])-1)
+	_, _, err = read(t, string(truncated))
+	assert.ErrorContains(t, err, "truncated TLV")
+
+	version := v2(1, nil, false)
+	version[12] = 0x31
+	_, _, err = read(t, string(version))
+	assert.ErrorContains(t, err, "unsupported version")
+
+	_, _, err = read(t, string(v2(1, nil, false)[:20]))
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+func TestParseCIDRs(t *testing.T) {
+	prefixes, err := ParseCIDRs("10.0.0.0/8, 192.168.1.7 ,2001:db8::/32")
+	require.NoError(t, err)
+	assert.Equal(t, []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("192.168.1.7/32"),
+		netip.MustParsePrefix("2001:db8::/32"),
+	}, prefixes)
+
+	_, err = ParseCIDRs("10.0.0.0/33")
+	assert.Error(t, err)
+}
+
+func acceptOne(t *testing.T, trusted string, send string) (net.Conn, string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	prefixes, err := ParseCIDRs(trusted)
+	require.NoError(t, err)
+	pl := NewListener(ln, Config{Trusted: prefixes})
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { client.Close() })
+	_, err = io.WriteString(client, send)
+	require.NoError(t, err)
+
+	conn, err := pl.Accept()
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+	return conn, string(buf[:n])
+}
+
+func TestListenerReplacesAddressesForTrustedSources(t *testing.T) {
+	conn, data := acceptOne(t, "127.0.0.0/8", "PROXY TCP4 203.0.113.7 10.0.0.1 51234 443\r\nhello")
+	assert.Equal(t, "hello", data)
+	assert.Equal(t, "203.0.113.7:51234", conn.RemoteAddr().String())
+	assert.Equal(t, "10.0.0.1:443", conn.LocalAddr().String())
+}
+
+func TestListenerIgnoresHeaderFromUntrustedSources(t *testing.T) {
+	raw := "PROXY TCP4 203.0.113.7 10.0.0.1 51234 443\r\n"
+	conn, data := acceptOne(t, "10.0.0.0/8", raw)
+	assert.Equal(t, raw, data)
+	assert.True(t, strings.HasPrefix(conn.RemoteAddr().String(), "127.0.0.1:"))
+}
+
+func TestListenerRequiresHeaderFromTrustedSources(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	pl := NewListener(ln, Config{Trusted: []netip.Prefix{netip.MustParsePrefix("127.0.0.1/32")}})
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	defer client.Close()
+	_, err = io.WriteString(client, "GET / HTTP/1.1\r\n\r\n")
+	require.NoError(t, err)
+
+	conn, err := pl.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Read(make([]byte, 16))
+	assert.ErrorIs(t, err, ErrNoHeader)
+	assert.True(t, strings.HasPrefix(conn.RemoteAddr().String(), "127.0.0.1:"))
+}
@@ -0,0 +1,182 @@
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sunilpar/My-Own-Http-Server/internal/request"
+	"github.com/sunilpar/My-Own-Http-Server/internal/response"
+	"github.com/sunilpar/My-Own-Http-Server/internal/server"
+)
+
+// KeyFunc picks the bucket a request is charged to. Requests with an empty
+// key are not limited.
+type KeyFunc func(req *request.Request) string
+
+// ByClientIP keys on the client address resolved past trusted proxies,
+// falling back to the connection's remote address.
+func ByClientIP(req *request.Request) string {
+	if req.Origin.IP.IsValid() {
+		return req.Origin.IP.String()
+	}
+	return ByRemoteIP(req)
+}
+
+// ByRemoteIP keys on the IP of the connection's remote address. Behind a
+// proxy every request shares one key; use ByClientIP there.
+func ByRemoteIP(req *request.Request) string {
+	if req.RemoteAddr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr.String())
+	if err != nil {
+		return req.RemoteAddr.String()
+	}
+	return host
+}
+
+func ByHeader(name string) KeyFunc {
+	return func(req *request.Request) string {
+		return req.Headers.Get(name)
+	}
+}
+
+// ByRoute keys on the matched route pattern, so it only works for limiters
+// installed as router group middleware.
+func ByRoute(req *request.Request) string {
+	return req.Pattern
+}
+
+type Config struct {
+	// Rate is the sustained number of requests per second allowed per key.
+	Rate float64
+	// Burst is the bucket size: how many requests may arrive at once.
+	Burst int
+	// Key defaults to ByClientIP.
+	Key KeyFunc
+	// IdleTTL is how long an untouched key is kept; it defaults to the time
+	// an empty bucket takes to refill, after which it is indistinguishable
+	// from a new one.
+	IdleTTL time.Duration
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter is a token bucket per key.
+type Limiter struct {
+	cfg Config
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type Decision struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration
+	RetryAfter time.Duration
+}
+
+func New(cfg Config) *Limiter {
+	if cfg.Rate <= 0 || cfg.Burst <= 0 {
+		panic("ratelimit: rate and burst must be positive")
+	}
+	if cfg.Key == nil {
+		cfg.Key = ByClientIP
+	}
+	if cfg.IdleTTL <= 0 {
+		cfg.IdleTTL = time.Duration(float64(cfg.Burst) / cfg.Rate * float64(time.Second))
+	}
+	return &Limiter{cfg: cfg, buckets: make(map[string]*bucket)}
+}
+
+func (l *Limiter) Allow(key string, now time.Time) Decision {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) > l.cfg.IdleTTL {
+		l.sweep(now)
+	}
+
+	burst := float64(l.cfg.Burst)
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: burst, last: now}
+		l.buckets[key] = b
+	}
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(burst, b.tokens+elapsed*l.cfg.Rate)
+	}
+	b.last = now
+
+	d := Decision{Limit: l.cfg.Burst}
+	if b.tokens >= 1 {
+		b.tokens--
+		d.Allowed = true
+	} else {
+		d.RetryAfter = l.seconds(1 - b.tokens)
+	}
+	d.Remaining = int(b.tokens)
+	d.Reset = l.seconds(burst - b.tokens)
+	return d
+}
+
+func (l *Limiter) seconds(tokens float64) time.Duration {
+	return time.Duration(tokens / l.cfg.Rate * float64(time.Second))
+}
+
+func (l *Limiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		if now.Sub(b.last) > l.cfg.IdleTTL {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+// Len returns the number of keys currently tracked.
+func (l *Limiter) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.buckets)
+}
+
+// Middleware sets the RateLimit-* headers on every response and answers
+// 429 with Retry-After once a key's bucket is empty.
+func (l *Limiter) Middleware(next server.Handler) server.Handler {
+	window := int(math.Ceil(float64(l.cfg.Burst) / l.cfg.Rate))
+	policy := fmt.Sprintf("%d;w=%d", l.cfg.Burst, window)
+
+	return func(w *response.Writer, req *request.Request) {
+		key := l.cfg.Key(req)
+		if key == "" {
+			next(w, req)
+			return
+		}
+		d := l.Allow(key, time.Now())
+		w.Header.Set("RateLimit-Policy", policy)
+		w.Header.Set("RateLimit-Limit", strconv.Itoa(d.Limit))
+		w.Header.Set("RateLimit-Remaining", strconv.Itoa(d.Remaining))
+		w.Header.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(d.Reset)))
+		if d.Allowed {
+			next(w, req)
+			return
+		}
+
+		w.Header.Set("Retry-After", strconv.Itoa(ceilSeconds(d.RetryAfter)))
+		response.WriteText(w, response.StatusTooManyRequests, "Too Many Requests")
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,91 @@
+package ratelimit
+
+import (
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunilpar/My-Own-Http-Server/internal/request"
+	"github.com/sunilpar/My-Own-Http-Server/internal/response"
+	"github.com/sunilpar/My-Own-Http-Server/internal/testutil"
+)
+
+func TestBurstThenRefill(t *testing.T) {
+	l := New(Config{Rate: 2, Burst: 3})
+	now := time.Unix(1000, 0)
+
+	for i := 0; i < 3; i++ {
+		d := l.Allow("a", now)
+		require.True(t, d.Allowed)
+		assert.Equal(t, 2-i, d.Remaining)
+	}
+	d := l.Allow("a", now)
+	assert.False(t, d.Allowed)
+	assert.Equal(t, 500*time.Millisecond, d.RetryAfter)
+	assert.Equal(t, 1500*time.Millisecond, d.Reset)
+
+	// other keys have their own bucket
+	assert.True(t, l.Allow("b", now).Allowed)
+
+	d = l.Allow("a", now.Add(500*time.Millisecond))
+	assert.True(t, d.Allowed)
+	assert.False(t, l.Allow("a", now.Add(500*time.Millisecond)).Allowed)
+}
+
+func TestIdleKeysAreEvicted(t *testing.T) {
+	l := New(Config{Rate: 1, Burst: 2})
+	now := time.Unix(1000, 0)
+	l.Allow("a", now)
+	l.Allow("b", now)
+	assert.Equal(t, 2, l.Len())
+
+	l.Allow("c", now.Add(3*time.Second))
+	assert.Equal(t, 1, l.Len())
+}
+
+// requestFrom returns a request arriving from the remote address.
+func requestFrom(t *testing.T, remote string) *request.Request {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: a\r\n\r\n"))
+	require.NoError(t, err)
+	req.RemoteAddr, err = net.ResolveTCPAddr("tcp", remote)
+	require.NoError(t, err)
+	return req
+}
+
+func TestMiddlewareKeysOnRemoteIP(t *testing.T) {
+	l := New(Config{Rate: 1, Burst: 1})
+	h := l.Middleware(func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusOK)
+		w.Header.Set("Content-Length", "0")
+		w.WriteHeaders(w.Header)
+	})
+
+	resp := testutil.ServeRequest(t, h, requestFrom(t, "10.0.0.1:1111"))
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK"))
+	assert.Contains(t, resp, "ratelimit-limit: 1\r\n")
+	assert.Contains(t, resp, "ratelimit-remaining: 0\r\n")
+	assert.Contains(t, resp, "ratelimit-policy: 1;w=1\r\n")
+
+	// same IP from another port shares the bucket
+	resp = testutil.ServeRequest(t, h, requestFrom(t, "10.0.0.1:2222"))
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 429 Too Many Requests"))
+	assert.Contains(t, resp, "retry-after: 1\r\n")
+
+	resp = testutil.ServeRequest(t, h, requestFrom(t, "10.0.0.2:1111"))
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK"))
+}
+
+func TestByClientIPPrefersOrigin(t *testing.T) {
+	req := &request.Request{}
+	req.RemoteAddr, _ = net.ResolveTCPAddr("tcp", "10.0.0.1:1111")
+	assert.Equal(t, "10.0.0.1", ByClientIP(req))
+
+	req.Origin.IP = netip.MustParseAddr("198.51.100.20")
+	assert.Equal(t, "198.51.100.20", ByClientIP(req))
+}
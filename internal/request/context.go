@@ -0,0 +1,57 @@
+package request
+
+import (
+	"context"
+	"net"
+)
+
+type contextKey int
+
+const (
+	requestIDKey contextKey = iota
+	remoteAddrKey
+)
+
+// Context returns the request's context. For requests read by the server it
+// is cancelled when the client disconnects, the server is closed or the
+// handler deadline passes.
+func (r *Request) Context() context.Context {
+	if r.ctx != nil {
+		return r.ctx
+	}
+	return context.Background()
+}
+
+// WithContext returns a shallow copy of r with its context changed to ctx.
+func (r *Request) WithContext(ctx context.Context) *Request {
+	if ctx == nil {
+		panic("nil context")
+	}
+	r2 := new(Request)
+	*r2 = *r
+	r2.ctx = ctx
+	return r2
+}
+
+// ID returns the request ID assigned by the server.
+func (r *Request) ID() string {
+	return IDFromContext(r.Context())
+}
+
+func ContextWithID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+func IDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+func ContextWithRemoteAddr(ctx context.Context, addr net.Addr) context.Context {
+	return context.WithValue(ctx, remoteAddrKey, addr)
+}
+
+func RemoteAddrFromContext(ctx context.Context) net.Addr {
+	addr, _ := ctx.Value(remoteAddrKey).(net.Addr)
+	return addr
+}
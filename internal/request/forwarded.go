@@ -0,0 +1,268 @@
+package request
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// Origin is the client as seen past any trusted proxies. Without a trusted
+// proxy in front, it is just the connection's peer, scheme and Host header.
+type Origin struct {
+	IP     netip.Addr
+	Scheme string
+	Host   string
+}
+
+// Hostname returns the host r was addressed to, without port or brackets
+// and in lower case, for matching against configured names. It is the host
+// resolved past trusted proxies, falling back to the absolute-form target
+// and then the Host header when the server did not resolve one.
+func (r *Request) Hostname() string {
+	host := r.Origin.Host
+	if host == "" {
+		host = r.RequestLine.Host
+	}
+	if host == "" {
+		host = r.Headers.Get("Host")
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(strings.Trim(host, "[]"))
+}
+
+// ForwardedElement is one hop of an RFC 7239 Forwarded header. Values are
+// unquoted; For and By may hold "unknown" or an obfuscated identifier
+// rather than an address.
+type ForwardedElement struct {
+	For   string
+	By    string
+	Proto string
+	Host  string
+}
+
+// ParseForwarded parses the value of a Forwarded header, one element per
+// proxy in the order they were added. Unknown parameters are ignored.
+func ParseForwarded(s string) ([]ForwardedElement, error) {
+	var elems []ForwardedElement
+	var cur ForwardedElement
+	for {
+		s = strings.TrimLeft(s, " \t")
+		eq := strings.IndexByte(s, '=')
+		if eq <= 0 {
+			return nil, fmt.Errorf("forwarded: missing parameter in %q", s)
+		}
+		key := strings.ToLower(s[:eq])
+		for _, r := range key {
+			if !isTokenChar(r) {
+				return nil, fmt.Errorf("forwarded: invalid parameter name %q", key)
+			}
+		}
+		s = s[eq+1:]
+
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			var err error
+			value, s, err = unquote(s)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			end := strings.IndexAny(s, ";, \t")
+			if end == -1 {
+				end = len(s)
+			}
+			value, s = s[:end], s[end:]
+			if value == "" {
+				return nil, fmt.Errorf("forwarded: empty value for %q", key)
+			}
+		}
+		switch key {
+		case "for":
+			cur.For = value
+		case "by":
+			cur.By = value
+		case "proto":
+			cur.Proto = value
+		case "host":
+			cur.Host = value
+		}
+
+		s = strings.TrimLeft(s, " \t")
+		if s == "" {
+			return append(elems, cur), nil
+		}
+		switch s[0] {
+		case ';':
+		case ',':
+			elems = append(elems, cur)
+			cur = ForwardedElement{}
+		default:
+			return nil, fmt.Errorf("forwarded: unexpected %q", s)
+		}
+		s = s[1:]
+	}
+}
+
+// unquote reads a quoted-string from the start of s and returns its value
+// and whatever follows it.
+func unquote(s string) (string, string, error) {
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			return b.String(), s[i+1:], nil
+		case '\\':
+			i++
+			if i == len(s) {
+				return "", "", errors.New("forwarded: unterminated quoted string")
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return "", "", errors.New("forwarded: unterminated quoted string")
+}
+
+func isTokenChar(r rune) bool {
+	return r < 0x7f && (r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' ||
+		strings.ContainsRune("!#$%&'*+-.^_`|~", r))
+}
+
+// HeaderSource names the forwarding headers a set of proxies writes.
+type HeaderSource int
+
+const (
+	// XForwardedFor is X-Forwarded-For with X-Forwarded-Proto and -Host.
+	XForwardedFor HeaderSource = iota
+	// Forwarded is the RFC 7239 Forwarded header.
+	Forwarded
+)
+
+// TrustedProxies describes the proxies in front of the server.
+type TrustedProxies struct {
+	Prefixes []netip.Prefix
+	// HeaderSource is the only header read. The other kind is ignored
+	// even when present, since the proxies may pass it on from the client
+	// untouched.
+	HeaderSource HeaderSource
+}
+
+// ResolveOrigin works out where req came from. Forwarding headers are only
+// believed when the connection's peer is a trusted proxy, and are then
+// followed from the nearest hop back for as long as each address is itself
+// trusted. The first untrusted address is the client.
+func ResolveOrigin(req *Request, proxies TrustedProxies) Origin {
+	trusted := proxies.Prefixes
+	o := Origin{Scheme: "http", Host: req.RequestLine.Host}
+	if req.TLS != nil {
+		o.Scheme = "https"
+	}
+	if o.Host == "" {
+		o.Host = req.Headers.Get("Host")
+	}
+	if req.RemoteAddr != nil {
+		o.IP, _ = parseNode(req.RemoteAddr.String())
+	}
+	if !isTrusted(o.IP, trusted) {
+		return o
+	}
+
+	hops := forwardedHops(req, proxies.HeaderSource)
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := hops[i]
+		if hop.Proto != "" {
+			o.Scheme = strings.ToLower(hop.Proto)
+		}
+		if hop.Host != "" {
+			o.Host = hop.Host
+		}
+		ip, ok := parseNode(hop.For)
+		if !ok {
+			// an unknown or obfuscated hop; the nearest known address
+			// is the best we have
+			break
+		}
+		o.IP = ip
+		if !isTrusted(ip, trusted) {
+			break
+		}
+	}
+	return o
+}
+
+func forwardedHops(req *Request, source HeaderSource) []ForwardedElement {
+	if source == Forwarded {
+		v := req.Headers.Get("Forwarded")
+		if v == "" {
+			return nil
+		}
+		hops, err := ParseForwarded(v)
+		if err != nil {
+			return nil
+		}
+		return hops
+	}
+
+	split := func(name string) []string {
+		v := req.Headers.Get(name)
+		if v == "" {
+			return nil
+		}
+		parts := strings.Split(v, ",")
+		for i := range parts {
+			parts[i] = strings.TrimSpace(parts[i])
+		}
+		return parts
+	}
+	fors := split("X-Forwarded-For")
+	protos := split("X-Forwarded-Proto")
+	hosts := split("X-Forwarded-Host")
+
+	// A single proto or host applies to every hop; a list lines up with
+	// X-Forwarded-For from the right.
+	pick := func(values []string, i int) string {
+		if len(values) == 1 {
+			return values[0]
+		}
+		if j := len(values) - (len(fors) - i); j >= 0 && j < len(values) {
+			return values[j]
+		}
+		return ""
+	}
+	hops := make([]ForwardedElement, len(fors))
+	for i, f := range fors {
+		hops[i] = ForwardedElement{For: f, Proto: pick(protos, i), Host: pick(hosts, i)}
+	}
+	return hops
+}
+
+// parseNode accepts an address with or without a port, IPv6 addresses
+// optionally in brackets.
+func parseNode(s string) (netip.Addr, bool) {
+	if ip, err := netip.ParseAddr(s); err == nil {
+		return ip.Unmap(), true
+	}
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	ip, err := netip.ParseAddr(strings.Trim(s, "[]"))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return ip.Unmap(), true
+}
+
+func isTrusted(ip netip.Addr, trusted []netip.Prefix) bool {
+	if !ip.IsValid() {
+		return false
+	}
+	for _, p := range trusted {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,128 @@
+package request
+
+import (
+	"crypto/tls"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunilpar/My-Own-Http-Server/internal/headers"
+)
+
+func TestParseForwarded(t *testing.T) {
+	elems, err := ParseForwarded(`for="[2001:db8::1]:4711";proto=https;host=example.com, For=192.0.2.43 ;by=10.0.0.1`)
+	require.NoError(t, err)
+	assert.Equal(t, []ForwardedElement{
+		{For: "[2001:db8::1]:4711", Proto: "https", Host: "example.com"},
+		{For: "192.0.2.43", By: "10.0.0.1"},
+	}, elems)
+
+	elems, err = ParseForwarded(`for="_hidden\"x", for=unknown`)
+	require.NoError(t, err)
+	assert.Equal(t, `_hidden"x`, elems[0].For)
+	assert.Equal(t, "unknown", elems[1].For)
+
+	for _, bad := range []string{"", "for", `for="open`, "for=", "for=a b", "f r=x"} {
+		_, err := ParseForwarded(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func forwardedRequest(remote string, hdrs map[string]string) *Request {
+	req := &Request{Headers: headers.NewHeaders()}
+	req.Headers.Set("Host", "internal:8080")
+	for k, v := range hdrs {
+		req.Headers.Set(k, v)
+	}
+	req.RemoteAddr, _ = net.ResolveTCPAddr("tcp", remote)
+	return req
+}
+
+var trustedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("10.0.0.0/8"),
+	netip.MustParsePrefix("127.0.0.1/32"),
+}
+
+var (
+	trustedProxies    = TrustedProxies{Prefixes: trustedPrefixes}
+	trustedForwarders = TrustedProxies{Prefixes: trustedPrefixes, HeaderSource: Forwarded}
+)
+
+func TestResolveOriginIgnoresUntrustedPeers(t *testing.T) {
+	req := forwardedRequest("203.0.113.9:5000", map[string]string{
+		"X-Forwarded-For":   "1.2.3.4",
+		"X-Forwarded-Proto": "https",
+		"Forwarded":         "for=5.6.7.8",
+	})
+	req.TLS = &tls.ConnectionState{}
+	assert.Equal(t, Origin{
+		IP:     netip.MustParseAddr("203.0.113.9"),
+		Scheme: "https",
+		Host:   "internal:8080",
+	}, ResolveOrigin(req, trustedProxies))
+}
+
+func TestResolveOriginWalksTrustedHops(t *testing.T) {
+	// the client prepended a spoofed hop; only the part appended by our
+	// own proxies is believed
+	req := forwardedRequest("10.0.0.2:5000", map[string]string{
+		"X-Forwarded-For":   "6.6.6.6, 198.51.100.20, 10.0.0.7",
+		"X-Forwarded-Proto": "https",
+		"X-Forwarded-Host":  "www.example.com",
+	})
+	assert.Equal(t, Origin{
+		IP:     netip.MustParseAddr("198.51.100.20"),
+		Scheme: "https",
+		Host:   "www.example.com",
+	}, ResolveOrigin(req, trustedProxies))
+}
+
+func TestResolveOriginReadsOnlyConfiguredHeader(t *testing.T) {
+	req := forwardedRequest("127.0.0.1:5000", map[string]string{
+		"Forwarded":       `for="[2001:db8::7]:1234";proto=HTTPS;host=shop.example, for=10.1.2.3;proto=http`,
+		"X-Forwarded-For": "1.2.3.4",
+	})
+	assert.Equal(t, Origin{
+		IP:     netip.MustParseAddr("2001:db8::7"),
+		Scheme: "https",
+		Host:   "shop.example",
+	}, ResolveOrigin(req, trustedForwarders))
+	assert.Equal(t, Origin{
+		IP:     netip.MustParseAddr("1.2.3.4"),
+		Scheme: "http",
+		Host:   "internal:8080",
+	}, ResolveOrigin(req, trustedProxies))
+
+	// a client cannot get around a proxy that only sets X-Forwarded-For by
+	// adding its own Forwarded, nor the other way round
+	req = forwardedRequest("10.0.0.2:5000", map[string]string{"Forwarded": "for=6.6.6.6"})
+	assert.Equal(t, netip.MustParseAddr("10.0.0.2"), ResolveOrigin(req, trustedProxies).IP)
+	req = forwardedRequest("10.0.0.2:5000", map[string]string{"X-Forwarded-For": "6.6.6.6"})
+	assert.Equal(t, netip.MustParseAddr("10.0.0.2"), ResolveOrigin(req, trustedForwarders).IP)
+}
+
+func TestResolveOriginStopsAtObfuscatedHop(t *testing.T) {
+	req := forwardedRequest("10.0.0.2:5000", map[string]string{
+		"Forwarded": "for=_client, for=10.0.0.9",
+	})
+	assert.Equal(t, netip.MustParseAddr("10.0.0.9"), ResolveOrigin(req, trustedForwarders).IP)
+
+	req = forwardedRequest("10.0.0.2:5000", map[string]string{"Forwarded": "for=;"})
+	assert.Equal(t, netip.MustParseAddr("10.0.0.2"), ResolveOrigin(req, trustedForwarders).IP)
+}
+
+func TestHostname(t *testing.T) {
+	req := &Request{Headers: headers.NewHeaders()}
+	req.Headers.Set("Host", "Example.COM:8080")
+	assert.Equal(t, "example.com", req.Hostname())
+
+	req.RequestLine.Host = "[::1]:8080"
+	assert.Equal(t, "::1", req.Hostname())
+
+	// a host resolved past trusted proxies wins over the request's own
+	req.Origin.Host = "Public.example"
+	assert.Equal(t, "public.example", req.Hostname())
+}
@@ -0,0 +1,111 @@
+package request
+
+import (
+	"fmt"
+	"strings"
+)
+
+type PathOptions struct {
+	// Normalize resolves dot-segments, collapses repeated slashes and decodes
+	// percent-encoded unreserved characters before the path is handed out.
+	Normalize bool
+	// AllowEncodedSlash accepts %2F in paths. It is kept encoded in Path so
+	// that it can never act as a segment separator.
+	AllowEncodedSlash bool
+	// AllowNUL accepts %00 in paths.
+	AllowNUL bool
+}
+
+var DefaultPathOptions = PathOptions{Normalize: true}
+
+// canonicalPath turns a raw, still-escaped path into the decoded form given
+// to handlers. The raw form is left untouched in RequestLine.RawPath.
+func canonicalPath(raw string, opts PathOptions) (string, error) {
+	escaped, err := decodeUnreserved(raw, opts)
+	if err != nil {
+		return "", err
+	}
+	if opts.Normalize {
+		escaped = CleanPath(escaped)
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(escaped); i++ {
+		if escaped[i] != '%' {
+			b.WriteByte(escaped[i])
+			continue
+		}
+		c := unhex(escaped[i+1])<<4 | unhex(escaped[i+2])
+		if c == '/' {
+			b.WriteString("%2F")
+		} else {
+			b.WriteByte(c)
+		}
+		i += 2
+	}
+	return b.String(), nil
+}
+
+// decodeUnreserved validates every percent-encoding in s, applies the
+// encoded slash and NUL policies and decodes the octets that never needed
+// escaping (RFC 3986 section 2.3), so that %2e%2e is seen as a dot-segment.
+func decodeUnreserved(s string, opts PathOptions) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+2 >= len(s) || !isHex(s[i+1]) || !isHex(s[i+2]) {
+			return "", fmt.Errorf("invalid percent-encoding in %q", s)
+		}
+		c := unhex(s[i+1])<<4 | unhex(s[i+2])
+		switch {
+		case c == '/' && !opts.AllowEncodedSlash:
+			return "", fmt.Errorf("encoded slash in path")
+		case c == 0 && !opts.AllowNUL:
+			return "", fmt.Errorf("encoded NUL in path")
+		case isUnreserved(c):
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+		i += 2
+	}
+	return b.String(), nil
+}
+
+func isUnreserved(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		c == '-' || c == '.' || c == '_' || c == '~'
+}
+
+// CleanPath removes dot-segments and empty segments from p, always returning
+// a path rooted at "/". A trailing slash is preserved. The result can never
+// climb above the root.
+func CleanPath(p string) string {
+	segments := strings.Split(p, "/")
+	out := make([]string, 0, len(segments))
+	trailingSlash := false
+	for _, seg := range segments {
+		trailingSlash = false
+		switch seg {
+		case "":
+			trailingSlash = true
+		case ".":
+			trailingSlash = true
+		case "..":
+			if len(out) > 0 {
+				out = out[:len(out)-1]
+			}
+			trailingSlash = true
+		default:
+			out = append(out, seg)
+		}
+	}
+	cleaned := "/" + strings.Join(out, "/")
+	if trailingSlash && len(out) > 0 {
+		cleaned += "/"
+	}
+	return cleaned
+}
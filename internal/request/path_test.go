@@ -0,0 +1,64 @@
+package request
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCleanPath(t *testing.T) {
+	cases := map[string]string{
+		"/":             "/",
+		"":              "/",
+		"/a/../b":       "/b",
+		"//x":           "/x",
+		"/a//b///c":     "/a/b/c",
+		"/a/./b/":       "/a/b/",
+		"/a/b/..":       "/a/",
+		"/../../etc":    "/etc",
+		"/a/b/../../..": "/",
+	}
+	for in, want := range cases {
+		assert.Equal(t, want, CleanPath(in), in)
+	}
+}
+
+func TestCanonicalPathDecodesEncodedDotSegments(t *testing.T) {
+	path, err := canonicalPath("/static/%2e%2e/%2E%2E/secret%7e%41", DefaultPathOptions)
+	require.NoError(t, err)
+	assert.Equal(t, "/secret~A", path)
+}
+
+func TestCanonicalPathKeepsReservedEscapesUntilFinalDecode(t *testing.T) {
+	path, err := canonicalPath("/a%3Fb/%252e%252e/c", DefaultPathOptions)
+	require.NoError(t, err)
+	assert.Equal(t, "/a?b/%2e%2e/c", path)
+}
+
+func TestCanonicalPathPolicies(t *testing.T) {
+	_, err := canonicalPath("/a%2Fb", DefaultPathOptions)
+	assert.Error(t, err)
+	_, err = canonicalPath("/a%00b", DefaultPathOptions)
+	assert.Error(t, err)
+
+	path, err := canonicalPath("/a%2f..%2fb", PathOptions{Normalize: true, AllowEncodedSlash: true})
+	require.NoError(t, err)
+	assert.Equal(t, "/a%2F..%2Fb", path)
+
+	path, err = canonicalPath("/a%00b", PathOptions{AllowNUL: true})
+	require.NoError(t, err)
+	assert.Equal(t, "/a\x00b", path)
+
+	path, err = canonicalPath("/a/../b", PathOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "/a/../b", path)
+}
+
+func TestRequestKeepsRawPath(t *testing.T) {
+	rl, _, err := parseRequestLine([]byte("GET //a/./b/../%63?x=1 HTTP/1.1\r\n"), DefaultPathOptions)
+	require.NoError(t, err)
+	assert.Equal(t, "/a/c", rl.Path)
+	assert.Equal(t, "//a/./b/../%63", rl.RawPath)
+	assert.Equal(t, "//a/./b/../%63?x=1", rl.RequestTarget)
+}
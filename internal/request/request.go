@@ -1,10 +1,16 @@
 package request
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"strconv"
 	"strings"
+	"time"
 	"unicode"
 
 	"github.com/sunilpar/My-Own-Http-Server/internal/headers"
@@ -16,72 +22,272 @@ const (
 	stateInitialized parserState = iota
 	stateParsingHeaders
 	stateParsingBody
+	stateParsingChunked
 	stateDone
 )
 
+// ErrUnsupportedTransferEncoding is returned for a Transfer-Encoding other
+// than chunked, which a server answers with 501.
+var ErrUnsupportedTransferEncoding = errors.New("unsupported Transfer-Encoding")
+
+// maxChunkSizeLine bounds a chunk size line, extensions included.
+const maxChunkSizeLine = 4096
+
+type chunkState int
+
+const (
+	chunkSize chunkState = iota
+	chunkData
+	chunkDataEnd
+	chunkTrailer
+)
+
+// ParseError is returned by ReadRequest when the bytes received are not a
+// valid request. Kind names the part of the request that was rejected.
+type ParseError struct {
+	Kind string
+	Err  error
+}
+
+func (e *ParseError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+func (s parserState) kind() string {
+	switch s {
+	case stateInitialized:
+		return "request_line"
+	case stateParsingHeaders:
+		return "header"
+	default:
+		return "body"
+	}
+}
+
 type Request struct {
 	RequestLine RequestLine
 	Headers     headers.Headers
 	Body        []byte
-	state       parserState
-	bodyLength  int
+	// BodyReader is set instead of Body when the Reader was told to leave
+	// the body on the connection, see Reader.StreamBody.
+	BodyReader io.Reader
+
+	// Pattern is the route pattern that matched, set by the router.
+	Pattern string
+
+	// Connection details, filled in by the server.
+	RemoteAddr   net.Addr
+	LocalAddr    net.Addr
+	TLS          *tls.ConnectionState
+	ConnID       uint64
+	ConnRequests int
+	StartTime    time.Time
+
+	// Origin is the client past any trusted proxies, see ResolveOrigin.
+	Origin Origin
+
+	ctx        context.Context
+	pathValues map[string]string
+	state      parserState
+	bodyLength int
+	bodyRead   int
+	holdBody   bool
+	pathOpts   PathOptions
+
+	chunkState chunkState
+	chunkLeft  int64
+	trailer    headers.Headers
 }
 
 type RequestLine struct {
 	HttpVersion   string
 	RequestTarget string
 	Method        string
+
+	// Components of RequestTarget. Path and Query are percent-decoded;
+	// RawPath and RawQuery are as received.
+	Form     TargetForm
+	Scheme   string
+	Host     string
+	Path     string
+	RawPath  string
+	RawQuery string
+	Query    Query
+}
+
+var methods = map[string]bool{
+	"GET":     true,
+	"HEAD":    true,
+	"POST":    true,
+	"PUT":     true,
+	"DELETE":  true,
+	"CONNECT": true,
+	"OPTIONS": true,
+	"TRACE":   true,
+	"PATCH":   true,
+}
+
+// Reader reads successive requests from a connection, keeping any bytes
+// read past the end of one request for the next.
+type Reader struct {
+	PathOptions PathOptions
+	// StreamBody, if set, is asked about every request with a body once its
+	// headers are read. When it returns true, ReadRequest returns at once
+	// and the body is read through Request.BodyReader, which must reach
+	// EOF before the next request can be read.
+	StreamBody func(*Request) bool
+
+	streaming  *Request
+	reader     io.Reader
+	buf        []byte
+	readTo     int
+	reachedEOF bool
+}
+
+func NewReader(reader io.Reader) *Reader {
+	return &Reader{
+		PathOptions: DefaultPathOptions,
+		reader:      reader,
+		buf:         make([]byte, 8),
+	}
 }
 
 func RequestFromReader(reader io.Reader) (*Request, error) {
-	buf := make([]byte, 8)
-	readTo := 0
+	return NewReader(reader).ReadRequest()
+}
+
+// ReadRequest returns io.EOF if the reader is exhausted before any byte of
+// a new request, other than blank lines, arrives.
+func (rr *Reader) ReadRequest() (*Request, error) {
+	if rr.streaming != nil && rr.streaming.state != stateDone {
+		return nil, errors.New("previous request body not read to the end")
+	}
+	rr.streaming = nil
 	req := &Request{
-		state:   stateInitialized,
-		Headers: headers.NewHeaders(),
+		state:    stateInitialized,
+		Headers:  headers.NewHeaders(),
+		holdBody: rr.StreamBody != nil,
+		pathOpts: rr.PathOptions,
+	}
+	if rr.readTo > 0 {
+		req.StartTime = time.Now()
 	}
-	reachedEOF := false
 	for {
-		if readTo == len(buf) {
-			newBuf := make([]byte, len(buf)*2)
-			copy(newBuf, buf)
-			buf = newBuf
-		}
-		n, err := reader.Read(buf[readTo:])
-		if err != nil && err != io.EOF {
+		if err := rr.parseBuffered(req); err != nil {
 			return nil, err
 		}
-		if err == io.EOF {
-			reachedEOF = true
-		}
-		readTo += n
-		parsed, perr := req.parse(buf[:readTo], reachedEOF)
-		if perr != nil {
-			return nil, perr
+		if req.state == stateDone {
+			return req, nil
 		}
-		if parsed > 0 {
-			copy(buf, buf[parsed:readTo])
-			readTo -= parsed
+		if req.holdBody && req.inBody() {
+			req.holdBody = false
+			if rr.StreamBody(req) {
+				req.BodyReader = &bodyReader{rr: rr, req: req}
+				rr.streaming = req
+				return req, nil
+			}
+			continue
 		}
-		if reachedEOF {
-			if req.state == stateParsingBody {
-				if req.bodyLength > 0 && len(req.Body) < req.bodyLength {
-					return nil, fmt.Errorf("incomplete body: expected %d bytes, got %d", req.bodyLength, len(req.Body))
-				}
-				// If no Content-Length, treat whatever is received as valid body
-				req.state = stateDone
-			} else if req.state != stateDone {
-				return nil, fmt.Errorf("incomplete request")
+		if rr.reachedEOF {
+			if err := rr.endAtEOF(req); err != nil {
+				return nil, err
 			}
+			return req, nil
+		}
+		if err := rr.fill(req); err != nil {
+			return nil, err
 		}
+	}
+}
+
+// parseBuffered feeds the bytes read so far to req, dropping the ones it
+// consumed.
+func (rr *Reader) parseBuffered(req *Request) error {
+	parsed, perr := req.parse(rr.buf[:rr.readTo])
+	if perr != nil {
+		return &ParseError{Kind: req.state.kind(), Err: perr}
+	}
+	if parsed > 0 {
+		copy(rr.buf, rr.buf[parsed:rr.readTo])
+		rr.readTo -= parsed
+	}
+	return nil
+}
+
+// endAtEOF decides what an exhausted reader means for an unfinished req:
+// no request at all, or a truncated one.
+func (rr *Reader) endAtEOF(req *Request) error {
+	if req.state == stateParsingBody {
+		return &ParseError{Kind: "incomplete", Err: fmt.Errorf("incomplete body: expected %d bytes, got %d", req.bodyLength, req.bodyRead)}
+	}
+	if req.state == stateInitialized && rr.readTo == 0 {
+		return io.EOF
+	}
+	return &ParseError{Kind: "incomplete", Err: fmt.Errorf("incomplete request")}
+}
+
+func (rr *Reader) fill(req *Request) error {
+	if rr.readTo == len(rr.buf) {
+		newBuf := make([]byte, len(rr.buf)*2)
+		copy(newBuf, rr.buf)
+		rr.buf = newBuf
+	}
+	n, err := rr.reader.Read(rr.buf[rr.readTo:])
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if err == io.EOF {
+		rr.reachedEOF = true
+	}
+	if n > 0 && req.StartTime.IsZero() {
+		req.StartTime = time.Now()
+	}
+	rr.readTo += n
+	return nil
+}
+
+// bodyReader runs the body states of the parser on demand, handing out
+// what they append to Body.
+type bodyReader struct {
+	rr  *Reader
+	req *Request
+}
 
+func (b *bodyReader) Read(p []byte) (int, error) {
+	req := b.req
+	for len(req.Body) == 0 {
 		if req.state == stateDone {
-			return req, nil
+			return 0, io.EOF
+		}
+		if err := b.rr.parseBuffered(req); err != nil {
+			return 0, err
+		}
+		if len(req.Body) > 0 || req.state == stateDone {
+			continue
+		}
+		if b.rr.reachedEOF {
+			if err := b.rr.endAtEOF(req); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		if err := b.rr.fill(req); err != nil {
+			return 0, err
 		}
 	}
+	n := copy(p, req.Body)
+	req.Body = req.Body[n:]
+	if len(req.Body) == 0 {
+		req.Body = nil
+	}
+	return n, nil
 }
 
-func parseRequestLine(data []byte) (RequestLine, int, error) {
+func parseRequestLine(data []byte, opts PathOptions) (RequestLine, int, error) {
 	s := string(data)
 	i := strings.Index(s, "\r\n")
 	if i == -1 {
@@ -101,24 +307,30 @@ func parseRequestLine(data []byte) (RequestLine, int, error) {
 			return RequestLine{}, 0, fmt.Errorf("method must be all uppercase letters")
 		}
 	}
-	if method != "GET" && method != "POST" {
-		return RequestLine{}, 0, fmt.Errorf("method must be GET or POST")
+	if !methods[method] {
+		return RequestLine{}, 0, fmt.Errorf("unsupported method: %s", method)
 	}
 	if version != "HTTP/1.1" {
 		return RequestLine{}, 0, fmt.Errorf("unsupported HTTP version: %s", version)
 	}
-	return RequestLine{
+	rl := RequestLine{
 		Method:        method,
 		RequestTarget: target,
 		HttpVersion:   "1.1",
-	}, i + 2, nil
+	}
+	if err := parseTarget(method, target, &rl, opts); err != nil {
+		return RequestLine{}, 0, err
+	}
+	return rl, i + 2, nil
 }
 
-func (r *Request) parse(data []byte, eof bool) (int, error) {
+func (r *Request) parse(data []byte) (int, error) {
 	totalParsed := 0
 	for r.state != stateDone {
-		n, err := r.parseSingle(data[totalParsed:], eof)
-		// fmt.Printf("buff:%v eof:%v\n", string(data[totalParsed:]), eof)
+		if r.holdBody && r.inBody() {
+			break
+		}
+		n, err := r.parseSingle(data[totalParsed:])
 		if err != nil {
 			return totalParsed, err
 		}
@@ -130,10 +342,21 @@ func (r *Request) parse(data []byte, eof bool) (int, error) {
 	return totalParsed, nil
 }
 
-func (r *Request) parseSingle(data []byte, eof bool) (int, error) {
+func (r *Request) inBody() bool {
+	return r.state == stateParsingBody || r.state == stateParsingChunked
+}
+
+func (r *Request) parseSingle(data []byte) (int, error) {
 	switch r.state {
 	case stateInitialized:
-		rl, n, err := parseRequestLine(data)
+		// ignore empty lines preceding the request line
+		if len(data) >= 2 && data[0] == '\r' && data[1] == '\n' {
+			return 2, nil
+		}
+		if len(data) >= 1 && data[0] == '\n' {
+			return 1, nil
+		}
+		rl, n, err := parseRequestLine(data, r.pathOpts)
 		if err != nil || n == 0 {
 			return n, err
 		}
@@ -147,7 +370,22 @@ func (r *Request) parseSingle(data []byte, eof bool) (int, error) {
 			return 0, err
 		}
 		if done {
+			if err := r.checkHost(); err != nil {
+				return 0, err
+			}
 			contentLenStr := r.Headers.Get("Content-Length")
+			if te, ok := r.Headers["transfer-encoding"]; ok {
+				// both would let a front end and this server disagree on
+				// where the request ends, as in request smuggling
+				if contentLenStr != "" {
+					return 0, errors.New("both Content-Length and Transfer-Encoding")
+				}
+				if !strings.EqualFold(strings.TrimSpace(te), "chunked") {
+					return 0, fmt.Errorf("%w: %q", ErrUnsupportedTransferEncoding, te)
+				}
+				r.state = stateParsingChunked
+				return n, nil
+			}
 			if contentLenStr != "" {
 				length, err := strconv.Atoi(contentLenStr)
 				if err != nil || length < 0 {
@@ -160,31 +398,117 @@ func (r *Request) parseSingle(data []byte, eof bool) (int, error) {
 					r.state = stateParsingBody
 				}
 			} else {
-				//change when we know more about eof and keep alive requirements
-				if r.RequestLine.Method == "GET" {
-					r.state = stateDone
-				} else {
-					r.state = stateParsingBody
-				}
+				// without either header the body is empty (RFC 9112 6.3),
+				// so the next request can follow on the same connection
+				r.state = stateDone
 			}
 		}
 		return n, nil
 
 	case stateParsingBody:
+		// anything past Content-Length belongs to the next request
+		remaining := r.bodyLength - r.bodyRead
+		if len(data) > remaining {
+			data = data[:remaining]
+		}
 		r.Body = append(r.Body, data...)
-		if r.bodyLength > 0 {
-			if len(r.Body) > r.bodyLength {
-				return 0, fmt.Errorf("body larger than Content-Length")
-			}
-			if len(r.Body) == r.bodyLength {
-				r.state = stateDone
-			}
-		} else if eof {
+		r.bodyRead += len(data)
+		if r.bodyRead == r.bodyLength {
 			r.state = stateDone
 		}
 		return len(data), nil
 
+	case stateParsingChunked:
+		return r.parseChunked(data)
+
 	default:
 		return 0, fmt.Errorf("unknown state")
 	}
 }
+
+// parseChunked decodes a chunked body into Body. Chunk extensions are
+// ignored and trailer fields are read but dropped.
+func (r *Request) parseChunked(data []byte) (int, error) {
+	switch r.chunkState {
+	case chunkSize:
+		i := bytes.Index(data, []byte("\r\n"))
+		if i == -1 {
+			if len(data) > maxChunkSizeLine {
+				return 0, errors.New("chunk size line too long")
+			}
+			return 0, nil
+		}
+		sizeStr, _, _ := strings.Cut(string(data[:i]), ";")
+		sizeStr = strings.TrimSpace(sizeStr)
+		size, err := strconv.ParseInt(sizeStr, 16, 64)
+		if err != nil || size < 0 || sizeStr == "" || strings.ContainsAny(sizeStr, "+-") {
+			return 0, fmt.Errorf("invalid chunk size %q", sizeStr)
+		}
+		if size == 0 {
+			r.chunkState = chunkTrailer
+			r.trailer = headers.NewHeaders()
+		} else {
+			r.chunkState = chunkData
+			r.chunkLeft = size
+		}
+		return i + 2, nil
+
+	case chunkData:
+		n := int(min(int64(len(data)), r.chunkLeft))
+		r.Body = append(r.Body, data[:n]...)
+		r.chunkLeft -= int64(n)
+		if r.chunkLeft == 0 {
+			r.chunkState = chunkDataEnd
+		}
+		return n, nil
+
+	case chunkDataEnd:
+		if len(data) < 2 {
+			return 0, nil
+		}
+		if data[0] != '\r' || data[1] != '\n' {
+			return 0, errors.New("missing CRLF after chunk data")
+		}
+		r.chunkState = chunkSize
+		return 2, nil
+
+	default:
+		n, done, err := r.trailer.Parse(data)
+		if err != nil {
+			return 0, err
+		}
+		if done {
+			r.state = stateDone
+		}
+		return n, nil
+	}
+}
+
+// PathValue returns the value of a wildcard captured by the router, or "" if
+// there is none by that name.
+func (r *Request) PathValue(name string) string {
+	return r.pathValues[name]
+}
+
+func (r *Request) SetPathValue(name, value string) {
+	if r.pathValues == nil {
+		r.pathValues = make(map[string]string)
+	}
+	r.pathValues[name] = value
+}
+
+// checkHost enforces RFC 9112 section 3.2: an HTTP/1.1 request carries
+// exactly one Host header, and it must be a valid authority.
+func (r *Request) checkHost() error {
+	host, ok := r.Headers["host"]
+	if !ok {
+		return errors.New("missing Host header")
+	}
+	if strings.Contains(host, ",") {
+		return errors.New("multiple Host headers")
+	}
+	if strings.ContainsAny(host, " \t/\\?#@") {
+		return fmt.Errorf("invalid Host header %q", host)
+	}
+	return nil
+}
@@ -84,7 +84,7 @@ func TestBodyFromReader(t *testing.T) {
 		assert.Nil(t, r)
 	})
 
-	t.Run("No Content-Length but Body Exists", func(t *testing.T) {
+	t.Run("No Content-Length Means Empty Body", func(t *testing.T) {
 		reader := &chunkReader{
 			data: "POST /submit HTTP/1.1\r\n" +
 				" Host: localhost\r\n" +
@@ -92,11 +92,176 @@ func TestBodyFromReader(t *testing.T) {
 				"extra body here",
 			numBytesPerRead: 4,
 		}
-		r, err := RequestFromReader(reader)
+		rr := NewReader(reader)
+		r, err := rr.ReadRequest()
 		require.NoError(t, err)
 		require.NotNil(t, r)
-		assert.Equal(t, "extra body here", string(r.Body))
+		assert.Empty(t, r.Body)
+
+		// the bytes that follow are read as the next request
+		_, err = rr.ReadRequest()
+		assert.Error(t, err)
 	})
 }
 
+func TestReaderPipelinedRequests(t *testing.T) {
+	reader := &chunkReader{
+		data: "POST /first HTTP/1.1\r\n" +
+			"Host: localhost\r\n" +
+			"Content-Length: 5\r\n" +
+			"\r\n" +
+			"hello" +
+			"GET /second HTTP/1.1\r\n" +
+			"Host: localhost\r\n" +
+			"\r\n",
+		numBytesPerRead: 7,
+	}
+	rr := NewReader(reader)
+
+	r, err := rr.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/first", r.RequestLine.RequestTarget)
+	assert.Equal(t, "hello", string(r.Body))
+	assert.False(t, r.StartTime.IsZero())
+
+	r, err = rr.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/second", r.RequestLine.RequestTarget)
+	assert.Empty(t, r.Body)
+
+	_, err = rr.ReadRequest()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestChunkedBody(t *testing.T) {
+	reader := &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Host: localhost\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"5;name=value\r\nhello\r\n" +
+			"7\r\n world!\r\n" +
+			"0\r\n" +
+			"X-Checksum: abc\r\n" +
+			"\r\n" +
+			"GET /next HTTP/1.1\r\n" +
+			"Host: localhost\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	}
+	rr := NewReader(reader)
+
+	r, err := rr.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "hello world!", string(r.Body))
+
+	r, err = rr.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/next", r.RequestLine.RequestTarget)
+
+	_, err = RequestFromReader(&chunkReader{
+		data:            "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhel",
+		numBytesPerRead: 3,
+	})
+	assert.ErrorContains(t, err, "incomplete")
+
+	_, err = RequestFromReader(&chunkReader{
+		data:            "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\n",
+		numBytesPerRead: 3,
+	})
+	assert.ErrorContains(t, err, "invalid chunk size")
+}
+
+func TestStreamedBody(t *testing.T) {
+	reader := &chunkReader{
+		data: "PUT /big HTTP/1.1\r\n" +
+			"Host: localhost\r\n" +
+			"Content-Length: 11\r\n" +
+			"\r\n" +
+			"hello world" +
+			"POST /chunked HTTP/1.1\r\n" +
+			"Host: localhost\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"3\r\nabc\r\n0\r\n\r\n" +
+			"POST /small HTTP/1.1\r\n" +
+			"Host: localhost\r\n" +
+			"Content-Length: 2\r\n" +
+			"\r\n" +
+			"hi",
+		numBytesPerRead: 4,
+	}
+	rr := NewReader(reader)
+	rr.StreamBody = func(r *Request) bool { return r.RequestLine.Path != "/small" }
+
+	r, err := rr.ReadRequest()
+	require.NoError(t, err)
+	require.NotNil(t, r.BodyReader)
+	assert.Empty(t, r.Body)
+	_, err = rr.ReadRequest()
+	assert.ErrorContains(t, err, "not read to the end")
+	body, err := io.ReadAll(r.BodyReader)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(body))
+
+	r, err = rr.ReadRequest()
+	require.NoError(t, err)
+	body, err = io.ReadAll(r.BodyReader)
+	require.NoError(t, err)
+	assert.Equal(t, "abc", string(body))
+
+	r, err = rr.ReadRequest()
+	require.NoError(t, err)
+	assert.Nil(t, r.BodyReader)
+	assert.Equal(t, "hi", string(r.Body))
+
+	rr = NewReader(&chunkReader{data: "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 10\r\n\r\nshort", numBytesPerRead: 4})
+	rr.StreamBody = func(*Request) bool { return true }
+	r, err = rr.ReadRequest()
+	require.NoError(t, err)
+	body, err = io.ReadAll(r.BodyReader)
+	assert.ErrorContains(t, err, "incomplete body")
+	assert.Equal(t, "short", string(body))
+}
+
+func TestTransferEncodingRejected(t *testing.T) {
+	read := func(data string) (*Request, error) {
+		return RequestFromReader(&chunkReader{data: data, numBytesPerRead: 5})
+	}
+
+	_, err := read("POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 5\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n")
+	var perr *ParseError
+	require.ErrorAs(t, err, &perr)
+	assert.Equal(t, "header", perr.Kind)
+	assert.NotErrorIs(t, err, ErrUnsupportedTransferEncoding)
+
+	_, err = read("POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: gzip, chunked\r\n\r\n")
+	assert.ErrorIs(t, err, ErrUnsupportedTransferEncoding)
+
+	_, err = read("POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\nTransfer-Encoding: chunked\r\n\r\n")
+	assert.ErrorIs(t, err, ErrUnsupportedTransferEncoding)
+}
+
+func TestHostHeaderRequired(t *testing.T) {
+	read := func(data string) (*Request, error) {
+		return RequestFromReader(&chunkReader{data: data, numBytesPerRead: 3})
+	}
+
+	_, err := read("GET / HTTP/1.1\r\n\r\n")
+	var perr *ParseError
+	require.ErrorAs(t, err, &perr)
+	assert.Equal(t, "header", perr.Kind)
+	assert.ErrorContains(t, err, "missing Host")
+
+	_, err = read("GET / HTTP/1.1\r\nHost: a.example\r\nHost: b.example\r\n\r\n")
+	assert.ErrorContains(t, err, "multiple Host")
+
+	_, err = read("GET / HTTP/1.1\r\nHost: user@a.example\r\n\r\n")
+	assert.ErrorContains(t, err, "invalid Host")
+
+	r, err := read("OPTIONS * HTTP/1.1\r\nHost:\r\n\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, AsteriskForm, r.RequestLine.Form)
+}
+
 //!go test ./internal/request -v
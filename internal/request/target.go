@@ -0,0 +1,213 @@
+package request
+
+import (
+	"fmt"
+	"strings"
+)
+
+type TargetForm int
+
+const (
+	OriginForm TargetForm = iota
+	AbsoluteForm
+	AuthorityForm
+	AsteriskForm
+)
+
+type Query map[string][]string
+
+func (q Query) Get(key string) string {
+	if vs := q[key]; len(vs) > 0 {
+		return vs[0]
+	}
+	return ""
+}
+
+func (q Query) Has(key string) bool {
+	_, ok := q[key]
+	return ok
+}
+
+// parseTarget splits the request-target into its components according to
+// the four forms of RFC 9112 section 3.2.
+func parseTarget(method, target string, rl *RequestLine, opts PathOptions) error {
+	if target == "" {
+		return fmt.Errorf("empty request target")
+	}
+	for i := 0; i < len(target); i++ {
+		c := target[i]
+		if c <= ' ' || c >= 0x7f || c == '#' {
+			return fmt.Errorf("invalid character in request target: %q", c)
+		}
+	}
+
+	switch {
+	case target == "*":
+		if method != "OPTIONS" {
+			return fmt.Errorf("asterisk-form is only allowed for OPTIONS")
+		}
+		rl.Form = AsteriskForm
+		rl.Path = "*"
+		rl.RawPath = "*"
+		rl.Query = Query{}
+		return nil
+
+	case method == "CONNECT":
+		if !validAuthority(target) || !strings.Contains(target, ":") {
+			return fmt.Errorf("CONNECT requires authority-form target")
+		}
+		rl.Form = AuthorityForm
+		rl.Host = target
+		rl.Query = Query{}
+		return nil
+
+	case strings.HasPrefix(target, "/"):
+		rl.Form = OriginForm
+		return parsePathAndQuery(target, rl, opts)
+
+	default:
+		scheme, rest, ok := strings.Cut(target, "://")
+		if !ok || !validScheme(scheme) {
+			return fmt.Errorf("malformed request target: %q", target)
+		}
+		authority := rest
+		pathAndQuery := "/"
+		if i := strings.IndexAny(rest, "/?"); i != -1 {
+			authority = rest[:i]
+			pathAndQuery = rest[i:]
+			if pathAndQuery[0] == '?' {
+				pathAndQuery = "/" + pathAndQuery
+			}
+		}
+		if authority == "" || !validAuthority(authority) {
+			return fmt.Errorf("invalid authority in request target: %q", authority)
+		}
+		rl.Form = AbsoluteForm
+		rl.Scheme = strings.ToLower(scheme)
+		rl.Host = authority
+		return parsePathAndQuery(pathAndQuery, rl, opts)
+	}
+}
+
+func parsePathAndQuery(s string, rl *RequestLine, opts PathOptions) error {
+	rawPath, rawQuery, _ := strings.Cut(s, "?")
+	path, err := canonicalPath(rawPath, opts)
+	if err != nil {
+		return err
+	}
+	query, err := ParseQuery(rawQuery)
+	if err != nil {
+		return err
+	}
+	rl.RawPath = rawPath
+	rl.Path = path
+	rl.RawQuery = rawQuery
+	rl.Query = query
+	return nil
+}
+
+func ParseQuery(raw string) (Query, error) {
+	q := Query{}
+	for raw != "" {
+		var pair string
+		pair, raw, _ = strings.Cut(raw, "&")
+		if pair == "" {
+			continue
+		}
+		k, v, _ := strings.Cut(pair, "=")
+		key, err := unescape(k)
+		if err != nil {
+			return nil, err
+		}
+		value, err := unescape(v)
+		if err != nil {
+			return nil, err
+		}
+		q[key] = append(q[key], value)
+	}
+	return q, nil
+}
+
+// unescape decodes percent-encoded octets in a query component, where a '+'
+// also stands for a space.
+func unescape(s string) (string, error) {
+	if !strings.ContainsAny(s, "%+") {
+		return s, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '%':
+			if i+2 >= len(s) || !isHex(s[i+1]) || !isHex(s[i+2]) {
+				return "", fmt.Errorf("invalid percent-encoding in %q", s)
+			}
+			b.WriteByte(unhex(s[i+1])<<4 | unhex(s[i+2]))
+			i += 2
+		case s[i] == '+':
+			b.WriteByte(' ')
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String(), nil
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func unhex(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
+	}
+}
+
+func validScheme(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		isAlpha := 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+		if i == 0 && !isAlpha {
+			return false
+		}
+		if !isAlpha && !('0' <= c && c <= '9') && !strings.ContainsRune("+-.", rune(c)) {
+			return false
+		}
+	}
+	return true
+}
+
+func validAuthority(s string) bool {
+	if s == "" || strings.ContainsAny(s, "/?@") {
+		return false
+	}
+	host, port := s, ""
+	if strings.HasPrefix(s, "[") {
+		end := strings.Index(s, "]")
+		if end == -1 {
+			return false
+		}
+		host, port = s[:end+1], strings.TrimPrefix(s[end+1:], ":")
+		if s[end+1:] != "" && !strings.HasPrefix(s[end+1:], ":") {
+			return false
+		}
+	} else if i := strings.LastIndex(s, ":"); i != -1 {
+		host, port = s[:i], s[i+1:]
+	}
+	if host == "" {
+		return false
+	}
+	for i := 0; i < len(port); i++ {
+		if port[i] < '0' || port[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,57 @@
+package request
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestLineOriginFormWithQuery(t *testing.T) {
+	rl, _, err := parseRequestLine([]byte("GET /video%20clips?x=1&name=a+b%26c&x=2&flag HTTP/1.1\r\n"), DefaultPathOptions)
+	require.NoError(t, err)
+	assert.Equal(t, OriginForm, rl.Form)
+	assert.Equal(t, "/video clips", rl.Path)
+	assert.Equal(t, "/video%20clips", rl.RawPath)
+	assert.Equal(t, "x=1&name=a+b%26c&x=2&flag", rl.RawQuery)
+	assert.Equal(t, []string{"1", "2"}, rl.Query["x"])
+	assert.Equal(t, "a b&c", rl.Query.Get("name"))
+	assert.True(t, rl.Query.Has("flag"))
+}
+
+func TestRequestLineAbsoluteForm(t *testing.T) {
+	rl, _, err := parseRequestLine([]byte("GET HTTP://example.com:8080?q=1 HTTP/1.1\r\n"), DefaultPathOptions)
+	require.NoError(t, err)
+	assert.Equal(t, AbsoluteForm, rl.Form)
+	assert.Equal(t, "http", rl.Scheme)
+	assert.Equal(t, "example.com:8080", rl.Host)
+	assert.Equal(t, "/", rl.Path)
+	assert.Equal(t, "1", rl.Query.Get("q"))
+}
+
+func TestRequestLineAuthorityAndAsteriskForms(t *testing.T) {
+	rl, _, err := parseRequestLine([]byte("CONNECT example.com:443 HTTP/1.1\r\n"), DefaultPathOptions)
+	require.NoError(t, err)
+	assert.Equal(t, AuthorityForm, rl.Form)
+	assert.Equal(t, "example.com:443", rl.Host)
+
+	rl, _, err = parseRequestLine([]byte("OPTIONS * HTTP/1.1\r\n"), DefaultPathOptions)
+	require.NoError(t, err)
+	assert.Equal(t, AsteriskForm, rl.Form)
+}
+
+func TestRequestLineRejectsMalformedTargets(t *testing.T) {
+	for _, line := range []string{
+		"GET * HTTP/1.1\r\n",
+		"GET video HTTP/1.1\r\n",
+		"GET /a%zz HTTP/1.1\r\n",
+		"GET /a%2 HTTP/1.1\r\n",
+		"GET /a#frag HTTP/1.1\r\n",
+		"GET http:///path HTTP/1.1\r\n",
+		"CONNECT /path HTTP/1.1\r\n",
+		"CONNECT example.com HTTP/1.1\r\n",
+	} {
+		_, _, err := parseRequestLine([]byte(line), DefaultPathOptions)
+		assert.Error(t, err, line)
+	}
+}
@@ -3,7 +3,11 @@ package response
 import (
 	"errors"
 	"fmt"
+	"io"
 	"net"
+	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/sunilpar/My-Own-Http-Server/internal/headers"
 )
@@ -11,21 +15,70 @@ import (
 type StatusCode int
 
 const (
-	StatusOK                  StatusCode = 200
-	StatusBadRequest          StatusCode = 400
-	StatusInternalServerError StatusCode = 500
+	StatusOK                   StatusCode = 200
+	StatusNoContent            StatusCode = 204
+	StatusPartialContent       StatusCode = 206
+	StatusMovedPermanently     StatusCode = 301
+	StatusNotModified          StatusCode = 304
+	StatusBadRequest           StatusCode = 400
+	StatusForbidden            StatusCode = 403
+	StatusNotFound             StatusCode = 404
+	StatusMethodNotAllowed     StatusCode = 405
+	StatusPreconditionFailed   StatusCode = 412
+	StatusContentTooLarge      StatusCode = 413
+	StatusUnsupportedMediaType StatusCode = 415
+	StatusRangeNotSatisfiable  StatusCode = 416
+	StatusTooManyRequests      StatusCode = 429
+	StatusInternalServerError  StatusCode = 500
+	StatusNotImplemented       StatusCode = 501
+	StatusBadGateway           StatusCode = 502
+	StatusServiceUnavailable   StatusCode = 503
+	StatusGatewayTimeout       StatusCode = 504
 )
 
 func statusText(code StatusCode) string {
 	switch code {
 	case StatusOK:
 		return "OK"
+	case StatusNoContent:
+		return "No Content"
+	case StatusPartialContent:
+		return "Partial Content"
+	case StatusMovedPermanently:
+		return "Moved Permanently"
+	case StatusNotModified:
+		return "Not Modified"
 	case StatusBadRequest:
 		return "Bad Request"
+	case StatusForbidden:
+		return "Forbidden"
+	case StatusNotFound:
+		return "Not Found"
+	case StatusMethodNotAllowed:
+		return "Method Not Allowed"
+	case StatusPreconditionFailed:
+		return "Precondition Failed"
+	case StatusContentTooLarge:
+		return "Content Too Large"
+	case StatusUnsupportedMediaType:
+		return "Unsupported Media Type"
+	case StatusRangeNotSatisfiable:
+		return "Range Not Satisfiable"
+	case StatusTooManyRequests:
+		return "Too Many Requests"
 	case StatusInternalServerError:
 		return "Internal Server Error"
+	case StatusNotImplemented:
+		return "Not Implemented"
+	case StatusBadGateway:
+		return "Bad Gateway"
+	case StatusServiceUnavailable:
+		return "Service Unavailable"
+	case StatusGatewayTimeout:
+		return "Gateway Timeout"
 	default:
-		return ""
+		// codes passed through from elsewhere, e.g. by a proxy
+		return http.StatusText(int(code))
 	}
 }
 
@@ -37,22 +90,128 @@ const (
 	stateHeadersWritten
 	stateBodyWritten
 	stateTrailersWritten
+	stateComplete
 )
 
+// countingConn counts every byte written to the connection, status line
+// and framing included.
+type countingConn struct {
+	net.Conn
+	n       int64
+	discard bool
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	if c.discard {
+		return len(p), nil
+	}
+	n, err := c.Conn.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// ReadFrom hands the copy to the connection when it can do it itself, as a
+// *net.TCPConn does with sendfile or splice.
+func (c *countingConn) ReadFrom(r io.Reader) (int64, error) {
+	if c.discard {
+		return io.Copy(io.Discard, r)
+	}
+	var n int64
+	var err error
+	if rf, ok := c.Conn.(io.ReaderFrom); ok {
+		n, err = rf.ReadFrom(r)
+	} else {
+		n, err = io.Copy(WriterOnly{c.Conn}, r)
+	}
+	c.n += n
+	return n, err
+}
+
+// WriterOnly hides any ReadFrom method of the writer it wraps, so io.Copy
+// falls back to plain writes.
+type WriterOnly struct {
+	io.Writer
+}
+
+// BodyEncoder transforms a response body, e.g. compresses it, on its way to
+// the connection.
+type BodyEncoder interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// EncoderFunc is called when the headers are about to be sent. It returns
+// an encoder writing to dst, after adjusting h to describe the encoded
+// body, or nil to send the body as it is. The Writer takes care of framing
+// an encoded body with chunked transfer coding.
+type EncoderFunc func(status StatusCode, h headers.Headers, dst io.Writer) BodyEncoder
+
 type Writer struct {
-	conn   net.Conn
+	conn   *countingConn
 	state  writerState
 	Header headers.Headers
+
+	status        StatusCode
+	contentLength int64
+	chunked       bool
+	closeAfter    bool
+	bodyBytes     int64
+	discardBody   bool
+
+	encoderFunc EncoderFunc
+	encoder     BodyEncoder
 }
 
 func NewWriter(conn net.Conn) *Writer {
 	return &Writer{
-		conn:   conn,
+		conn:   &countingConn{Conn: conn},
 		state:  stateInitial,
 		Header: headers.NewHeaders(),
 	}
 }
 
+// DiscardBody drops everything written after the headers, which may still
+// describe the body, as the response to a HEAD request must.
+func (w *Writer) DiscardBody() {
+	w.discardBody = true
+}
+
+// bodyless reports whether the response can have no body, whatever its
+// headers say.
+func (w *Writer) bodyless() bool {
+	return w.discardBody || w.status < 200 || w.status == StatusNoContent || w.status == StatusNotModified
+}
+
+func (w *Writer) StatusWritten() bool {
+	return w.state != stateInitial
+}
+
+// Status returns the status code sent, or 0 if no status line was written.
+func (w *Writer) Status() StatusCode {
+	return w.status
+}
+
+// BytesWritten returns the number of body bytes sent, excluding chunk
+// framing and trailers.
+func (w *Writer) BytesWritten() int64 {
+	if w.conn.discard {
+		return 0
+	}
+	return w.bodyBytes
+}
+
+// WireBytes returns the total number of bytes sent on the connection for
+// this response.
+func (w *Writer) WireBytes() int64 {
+	return w.conn.n
+}
+
+// SetEncoder arranges for f to choose an encoding for the body once the
+// status and headers are known. It has no effect after WriteHeaders.
+func (w *Writer) SetEncoder(f EncoderFunc) {
+	w.encoderFunc = f
+}
+
 func (w *Writer) WriteStatusLine(code StatusCode) error {
 	if w.state != stateInitial {
 		return errors.New("status line already written")
@@ -60,6 +219,7 @@ func (w *Writer) WriteStatusLine(code StatusCode) error {
 	_, err := fmt.Fprintf(w.conn, "HTTP/1.1 %d %s\r\n", code, statusText(code))
 	if err == nil {
 		w.state = stateStatusWritten
+		w.status = code
 	}
 	return err
 }
@@ -68,39 +228,121 @@ func (w *Writer) WriteHeaders(h headers.Headers) error {
 	if w.state != stateStatusWritten {
 		return errors.New("must write status line before headers")
 	}
-	for key, val := range h {
-		_, err := fmt.Fprintf(w.conn, "%s: %s\r\n", key, val)
-		if err != nil {
-			return err
+	if w.encoderFunc != nil {
+		if enc := w.encoderFunc(w.status, h, chunkSink{w}); enc != nil {
+			h.Del("Content-Length")
+			h.Set("Transfer-Encoding", "chunked")
+			w.encoder = enc
+		}
+	}
+	for key := range h {
+		for _, val := range h.Values(key) {
+			_, err := fmt.Fprintf(w.conn, "%s: %s\r\n", key, val)
+			if err != nil {
+				return err
+			}
 		}
 	}
 	_, err := fmt.Fprint(w.conn, "\r\n")
 	if err == nil {
 		w.state = stateHeadersWritten
+		w.recordFraming(h)
+		w.conn.discard = w.bodyless()
 	}
 	return err
 }
 
+func (w *Writer) recordFraming(h headers.Headers) {
+	w.contentLength = -1
+	if cl := h.Get("Content-Length"); cl != "" {
+		if n, err := strconv.ParseInt(cl, 10, 64); err == nil && n >= 0 {
+			w.contentLength = n
+		}
+	}
+	w.chunked = strings.EqualFold(h.Get("Transfer-Encoding"), "chunked")
+	w.closeAfter = strings.EqualFold(h.Get("Connection"), "close")
+}
+
 func (w *Writer) WriteBody(p []byte) (int, error) {
-	if w.state != stateHeadersWritten {
+	if w.state != stateHeadersWritten && w.state != stateBodyWritten {
 		return 0, errors.New("must write headers before body")
 	}
 	w.state = stateBodyWritten
-	return w.conn.Write(p)
+	if w.encoder != nil {
+		return w.encoder.Write(p)
+	}
+	n, err := w.conn.Write(p)
+	w.bodyBytes += int64(n)
+	return n, err
 }
 
+// ReadFrom copies r into the body. On a plaintext TCP connection an
+// *os.File is sent without passing through user space. Chunked
+// responses, and connections that cannot do that such as TLS, are copied
+// through a buffer.
+func (w *Writer) ReadFrom(r io.Reader) (int64, error) {
+	if w.state != stateHeadersWritten && w.state != stateBodyWritten {
+		return 0, errors.New("must write headers before body")
+	}
+	if w.encoder != nil {
+		w.state = stateBodyWritten
+		return io.Copy(w.encoder, r)
+	}
+	if w.chunked {
+		return io.Copy(chunkWriter{w}, r)
+	}
+	w.state = stateBodyWritten
+	n, err := w.conn.ReadFrom(r)
+	w.bodyBytes += n
+	return n, err
+}
+
+type chunkWriter struct {
+	w *Writer
+}
+
+func (c chunkWriter) Write(p []byte) (int, error) {
+	return c.w.WriteChunkedBody(p)
+}
+
+// chunkSink frames whatever an encoder produces as chunks.
+type chunkSink struct {
+	w *Writer
+}
+
+func (c chunkSink) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	return c.w.writeChunk(p)
+}
+
+// WriteChunkedBody sends p as one chunk. With an encoder p is flushed
+// through it, so a streamed response still reaches the client as it is
+// produced.
 func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
 	if w.state != stateHeadersWritten && w.state != stateBodyWritten {
 		return 0, errors.New("must write headers before chunked body")
 	}
 	w.state = stateBodyWritten
+	if w.encoder != nil {
+		n, err := w.encoder.Write(p)
+		if err != nil {
+			return n, err
+		}
+		return n, w.encoder.Flush()
+	}
+	return w.writeChunk(p)
+}
 
+func (w *Writer) writeChunk(p []byte) (int, error) {
 	chunkSize := len(p)
 	_, err := fmt.Fprintf(w.conn, "%x\r\n", chunkSize)
 	if err != nil {
 		return 0, err
 	}
 	n, err := w.conn.Write(p)
+	w.bodyBytes += int64(n)
 	if err != nil {
 		return n, err
 	}
@@ -111,10 +353,24 @@ func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
 	return n, nil
 }
 
+// Flush pushes out anything an encoder is holding back. Without one the
+// body is never buffered and Flush does nothing.
+func (w *Writer) Flush() error {
+	if w.encoder == nil || (w.state != stateHeadersWritten && w.state != stateBodyWritten) {
+		return nil
+	}
+	return w.encoder.Flush()
+}
+
 func (w *Writer) WriteChunkedBodyDone() (int, error) {
-	if w.state != stateBodyWritten {
+	if w.state != stateHeadersWritten && w.state != stateBodyWritten {
 		return 0, errors.New("no chunked body started")
 	}
+	if w.encoder != nil {
+		if err := w.encoder.Close(); err != nil {
+			return 0, err
+		}
+	}
 	w.state = stateTrailersWritten
 	return fmt.Fprint(w.conn, "0\r\n")
 }
@@ -123,15 +379,58 @@ func (w *Writer) WriteTrailers(h headers.Headers) error {
 	if w.state != stateTrailersWritten {
 		return errors.New("must write chunked body done before trailers")
 	}
-	for key, val := range h {
-		_, err := fmt.Fprintf(w.conn, "%s: %s\r\n", key, val)
-		if err != nil {
-			return err
+	for key := range h {
+		for _, val := range h.Values(key) {
+			_, err := fmt.Fprintf(w.conn, "%s: %s\r\n", key, val)
+			if err != nil {
+				return err
+			}
 		}
 	}
 	_, err := fmt.Fprint(w.conn, "\r\n")
 	if err == nil {
-		w.state = stateBodyWritten
+		w.state = stateComplete
 	}
 	return err
 }
+
+// Finish terminates a chunked or encoded response whose trailers were never
+// written and reports whether the response was framed completely, which is
+// what allows the connection to be reused for another request.
+func (w *Writer) Finish() bool {
+	if w.encoder != nil && (w.state == stateHeadersWritten || w.state == stateBodyWritten) {
+		if _, err := w.WriteChunkedBodyDone(); err != nil {
+			return false
+		}
+	}
+	if w.state == stateTrailersWritten {
+		if _, err := fmt.Fprint(w.conn, "\r\n"); err != nil {
+			return false
+		}
+		w.state = stateComplete
+	}
+	if w.state < stateHeadersWritten || w.closeAfter {
+		return false
+	}
+	if w.bodyless() {
+		return true
+	}
+	if w.chunked {
+		return w.state == stateComplete
+	}
+	if w.contentLength >= 0 {
+		return w.bodyBytes == w.contentLength
+	}
+	return false
+}
+
+// WriteText sends a complete plain text response, using whatever headers
+// were already set on w.Header.
+func WriteText(w *Writer, code StatusCode, msg string) {
+	body := []byte(msg)
+	w.WriteStatusLine(code)
+	w.Header.Set("Content-Type", "text/plain")
+	w.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeaders(w.Header)
+	w.WriteBody(body)
+}
@@ -0,0 +1,169 @@
+package response
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadFromChunked(t *testing.T) {
+	conn, client := net.Pipe()
+	out := make(chan string)
+	go func() {
+		b, _ := io.ReadAll(client)
+		out <- string(b)
+	}()
+
+	w := NewWriter(conn)
+	w.WriteStatusLine(StatusOK)
+	w.Header.Set("Transfer-Encoding", "chunked")
+	w.WriteHeaders(w.Header)
+	n, err := w.ReadFrom(strings.NewReader("hello"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), n)
+	w.WriteChunkedBodyDone()
+	assert.True(t, w.Finish())
+	conn.Close()
+
+	assert.True(t, strings.HasSuffix(<-out, "\r\n\r\n5\r\nhello\r\n0\r\n\r\n"))
+}
+
+func TestBodylessResponsesAreComplete(t *testing.T) {
+	send := func(code StatusCode, head bool) (string, bool) {
+		conn, client := net.Pipe()
+		out := make(chan string)
+		go func() {
+			b, _ := io.ReadAll(client)
+			out <- string(b)
+		}()
+		w := NewWriter(conn)
+		if head {
+			w.DiscardBody()
+		}
+		w.WriteStatusLine(code)
+		w.Header.Set("Content-Length", "5")
+		w.WriteHeaders(w.Header)
+		w.WriteBody([]byte("hello"))
+		done := w.Finish()
+		conn.Close()
+		return <-out, done
+	}
+
+	out, done := send(StatusOK, true)
+	assert.True(t, done)
+	assert.True(t, strings.HasSuffix(out, "content-length: 5\r\n\r\n"), out)
+	out, done = send(StatusNotModified, false)
+	assert.True(t, done)
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n"), out)
+	_, done = send(StatusOK, false)
+	assert.True(t, done)
+}
+
+func TestReadFromBeforeHeaders(t *testing.T) {
+	conn, _ := net.Pipe()
+	defer conn.Close()
+	_, err := NewWriter(conn).ReadFrom(strings.NewReader("x"))
+	assert.Error(t, err)
+}
+
+// tcpPair returns both ends of a loopback TCP connection.
+func tcpPair(tb testing.TB) (server, client net.Conn) {
+	tb.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(tb, err)
+	defer ln.Close()
+	client, err = net.Dial("tcp", ln.Addr().String())
+	require.NoError(tb, err)
+	server, err = ln.Accept()
+	require.NoError(tb, err)
+	tb.Cleanup(func() {
+		server.Close()
+		client.Close()
+	})
+	return server, client
+}
+
+func tempFile(tb testing.TB, size int) *os.File {
+	tb.Helper()
+	path := filepath.Join(tb.TempDir(), "data")
+	require.NoError(tb, os.WriteFile(path, bytes.Repeat([]byte("0123456789abcdef"), size/16), 0o644))
+	f, err := os.Open(path)
+	require.NoError(tb, err)
+	tb.Cleanup(func() { f.Close() })
+	return f
+}
+
+func TestReadFromFile(t *testing.T) {
+	conn, client := tcpPair(t)
+	f := tempFile(t, 1<<20)
+	out := make(chan []byte)
+	go func() {
+		b, _ := io.ReadAll(client)
+		out <- b
+	}()
+
+	w := NewWriter(conn)
+	w.WriteStatusLine(StatusOK)
+	w.Header.Set("Content-Length", fmt.Sprintf("%d", 1<<20))
+	w.WriteHeaders(w.Header)
+	n, err := w.ReadFrom(f)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1<<20), n)
+	assert.Equal(t, int64(1<<20), w.BytesWritten())
+	assert.True(t, w.Finish())
+	conn.Close()
+
+	resp := <-out
+	assert.Equal(t, int64(len(resp)), w.WireBytes())
+	_, body, _ := bytes.Cut(resp, []byte("\r\n\r\n"))
+	assert.Len(t, body, 1<<20)
+}
+
+// BenchmarkFileBody compares sending a file with ReadFrom, which uses
+// sendfile on TCP, against copying it through a buffer with WriteBody.
+func BenchmarkFileBody(b *testing.B) {
+	const size = 8 << 20
+	for _, mode := range []string{"ReadFrom", "WriteBody"} {
+		b.Run(mode, func(b *testing.B) {
+			conn, client := tcpPair(b)
+			f := tempFile(b, size)
+			go io.Copy(io.Discard, client)
+
+			buf := make([]byte, 32<<10)
+			b.SetBytes(size)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				f.Seek(0, io.SeekStart)
+				w := NewWriter(conn)
+				w.WriteStatusLine(StatusOK)
+				w.Header.Set("Content-Length", fmt.Sprintf("%d", size))
+				w.WriteHeaders(w.Header)
+				var err error
+				if mode == "ReadFrom" {
+					_, err = w.ReadFrom(f)
+				} else {
+					_, err = io.CopyBuffer(bodyWriter{w}, f, buf)
+				}
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+type bodyWriter struct {
+	w *Writer
+}
+
+func (b bodyWriter) Write(p []byte) (int, error) {
+	return b.w.WriteBody(p)
+}
@@ -0,0 +1,124 @@
+package router
+
+import (
+	"fmt"
+	"strings"
+)
+
+type segmentKind int
+
+const (
+	segWildcard segmentKind = iota // {name...}
+	segParam                       // {name}
+	segLiteral
+)
+
+type segment struct {
+	kind  segmentKind
+	value string // literal text or parameter name
+}
+
+type pattern struct {
+	raw      string
+	host     string
+	segments []segment
+	trailing bool // pattern ends in "/"
+}
+
+// parsePattern accepts "[host]/path" where path segments are literals,
+// {name} for a single segment or, in last position only, {name...} for the
+// rest of the path.
+func parsePattern(s string) (*pattern, error) {
+	p := &pattern{raw: s}
+	slash := strings.Index(s, "/")
+	if slash == -1 {
+		return nil, fmt.Errorf("pattern %q has no path", s)
+	}
+	p.host = strings.ToLower(s[:slash])
+	path := s[slash:]
+	if path == "/" {
+		return p, nil
+	}
+	p.trailing = strings.HasSuffix(path, "/")
+
+	names := make(map[string]bool)
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	for i, part := range parts {
+		if !strings.HasPrefix(part, "{") {
+			if strings.ContainsAny(part, "{}") {
+				return nil, fmt.Errorf("pattern %q: bad segment %q", s, part)
+			}
+			p.segments = append(p.segments, segment{kind: segLiteral, value: part})
+			continue
+		}
+		if !strings.HasSuffix(part, "}") {
+			return nil, fmt.Errorf("pattern %q: unterminated wildcard %q", s, part)
+		}
+		name := part[1 : len(part)-1]
+		kind := segParam
+		if strings.HasSuffix(name, "...") {
+			if i != len(parts)-1 || p.trailing {
+				return nil, fmt.Errorf("pattern %q: %s must be the last segment", s, part)
+			}
+			name = strings.TrimSuffix(name, "...")
+			kind = segWildcard
+		}
+		if name == "" || names[name] {
+			return nil, fmt.Errorf("pattern %q: bad or duplicate wildcard name %q", s, name)
+		}
+		names[name] = true
+		p.segments = append(p.segments, segment{kind: kind, value: name})
+	}
+	return p, nil
+}
+
+// match reports whether path matches the pattern and returns the captured
+// wildcard values.
+func (p *pattern) match(path string) (map[string]string, bool) {
+	if len(p.segments) == 0 {
+		return nil, path == "/"
+	}
+	rest := strings.TrimPrefix(path, "/")
+	values := make(map[string]string)
+	for i, seg := range p.segments {
+		if seg.kind == segWildcard {
+			values[seg.value] = rest
+			return values, true
+		}
+		part, after, found := strings.Cut(rest, "/")
+		if part == "" {
+			return nil, false
+		}
+		if seg.kind == segLiteral && part != seg.value {
+			return nil, false
+		}
+		if seg.kind == segParam {
+			values[seg.value] = part
+		}
+		last := i == len(p.segments)-1
+		switch {
+		case !last && !found:
+			return nil, false
+		case last && p.trailing:
+			return values, found && after == ""
+		case last:
+			return values, !found
+		}
+		rest = after
+	}
+	return values, true
+}
+
+// moreSpecific orders patterns so that literal segments beat parameters,
+// parameters beat a trailing wildcard, and host patterns beat host-less ones.
+func (p *pattern) moreSpecific(o *pattern) bool {
+	if (p.host != "") != (o.host != "") {
+		return p.host != ""
+	}
+	for i := 0; i < len(p.segments) && i < len(o.segments); i++ {
+		if p.segments[i].kind != o.segments[i].kind {
+			return p.segments[i].kind > o.segments[i].kind
+		}
+	}
+	return len(p.segments) > len(o.segments)
+}
@@ -0,0 +1,196 @@
+package router
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/sunilpar/My-Own-Http-Server/internal/request"
+	"github.com/sunilpar/My-Own-Http-Server/internal/response"
+	"github.com/sunilpar/My-Own-Http-Server/internal/server"
+)
+
+type Middleware = server.Middleware
+
+type route struct {
+	method  string // "" matches any method
+	pattern *pattern
+	handler server.Handler
+}
+
+type Router struct {
+	RouteGroup
+	routes []*route
+
+	// NotFound, if set, replaces the default 404 response.
+	NotFound server.Handler
+}
+
+// RouteGroup registers routes under a shared prefix, wrapping each of them
+// in the group's middleware. Middleware added with Use only applies to
+// routes registered after it.
+type RouteGroup struct {
+	router     *Router
+	prefix     string
+	middleware []Middleware
+}
+
+func New() *Router {
+	r := &Router{}
+	r.RouteGroup.router = r
+	return r
+}
+
+func (g *RouteGroup) Use(mw ...Middleware) {
+	g.middleware = append(g.middleware, mw...)
+}
+
+func (g *RouteGroup) Group(prefix string, mw ...Middleware) *RouteGroup {
+	return &RouteGroup{
+		router:     g.router,
+		prefix:     g.prefix + strings.TrimSuffix(prefix, "/"),
+		middleware: append(append([]Middleware{}, g.middleware...), mw...),
+	}
+}
+
+// Handle registers h for method and pattern. A pattern may start with a host
+// name, as in "example.com/users/{id}"; an empty method matches any method.
+// A GET route also answers HEAD unless a HEAD route has the same pattern.
+func (g *RouteGroup) Handle(method, pat string, h server.Handler) {
+	if g.prefix != "" {
+		host, path := "", pat
+		if i := strings.Index(pat, "/"); i > 0 {
+			host, path = pat[:i], pat[i:]
+		}
+		pat = host + g.prefix + path
+	}
+	p, err := parsePattern(pat)
+	if err != nil {
+		panic(err)
+	}
+	h = server.NewChain(g.middleware...).Then(h)
+
+	r := g.router
+	for _, existing := range r.routes {
+		if existing.method == method && existing.pattern.raw == p.raw {
+			panic(fmt.Sprintf("router: %s %s registered twice", method, pat))
+		}
+	}
+	r.routes = append(r.routes, &route{method: method, pattern: p, handler: h})
+	sort.SliceStable(r.routes, func(i, j int) bool {
+		return r.routes[i].pattern.moreSpecific(r.routes[j].pattern)
+	})
+}
+
+func (g *RouteGroup) Get(pat string, h server.Handler)    { g.Handle("GET", pat, h) }
+func (g *RouteGroup) Post(pat string, h server.Handler)   { g.Handle("POST", pat, h) }
+func (g *RouteGroup) Put(pat string, h server.Handler)    { g.Handle("PUT", pat, h) }
+func (g *RouteGroup) Patch(pat string, h server.Handler)  { g.Handle("PATCH", pat, h) }
+func (g *RouteGroup) Delete(pat string, h server.Handler) { g.Handle("DELETE", pat, h) }
+
+// ServeRequest dispatches req to the most specific matching route.
+func (r *Router) ServeRequest(w *response.Writer, req *request.Request) {
+	host := req.Hostname()
+	path := req.RequestLine.Path
+
+	if req.RequestLine.Form == request.AsteriskForm {
+		r.serveOptions(w, r.allowed(host, "", true))
+		return
+	}
+
+	var pathMatched *route
+	for _, rt := range r.routes {
+		if rt.pattern.host != "" && rt.pattern.host != host {
+			continue
+		}
+		values, ok := rt.pattern.match(path)
+		if !ok {
+			continue
+		}
+		if pathMatched == nil {
+			pathMatched = rt
+		}
+		if !r.methodMatches(rt, req.RequestLine.Method) {
+			continue
+		}
+		for name, value := range values {
+			req.SetPathValue(name, value)
+		}
+		req.Pattern = rt.pattern.raw
+		rt.handler(w, req)
+		return
+	}
+
+	if pathMatched == nil {
+		if r.NotFound != nil {
+			r.NotFound(w, req)
+			return
+		}
+		response.WriteText(w, response.StatusNotFound, "Not Found")
+		return
+	}
+
+	allow := r.allowed(host, path, false)
+	if req.RequestLine.Method == "OPTIONS" {
+		r.serveOptions(w, allow)
+		return
+	}
+	w.Header.Set("Allow", strings.Join(allow, ", "))
+	response.WriteText(w, response.StatusMethodNotAllowed, "Method Not Allowed")
+}
+
+// methodMatches reports whether rt serves method. A GET route answers HEAD
+// too, unless a HEAD route was registered for the same pattern.
+func (r *Router) methodMatches(rt *route, method string) bool {
+	if rt.method == "" || rt.method == method {
+		return true
+	}
+	if method != "HEAD" || rt.method != "GET" {
+		return false
+	}
+	for _, other := range r.routes {
+		if other.method == "HEAD" && other.pattern.raw == rt.pattern.raw {
+			return false
+		}
+	}
+	return true
+}
+
+// allowed lists the methods registered for path, or for every path when
+// allPaths is set.
+func (r *Router) allowed(host, path string, allPaths bool) []string {
+	seen := map[string]bool{"OPTIONS": true}
+	for _, rt := range r.routes {
+		if rt.pattern.host != "" && rt.pattern.host != host {
+			continue
+		}
+		if !allPaths {
+			if _, ok := rt.pattern.match(path); !ok {
+				continue
+			}
+		}
+		if rt.method == "" {
+			for _, m := range []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"} {
+				seen[m] = true
+			}
+			continue
+		}
+		seen[rt.method] = true
+		if rt.method == "GET" {
+			seen["HEAD"] = true
+		}
+	}
+	methods := make([]string, 0, len(seen))
+	for m := range seen {
+		methods = append(methods, m)
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+func (r *Router) serveOptions(w *response.Writer, allow []string) {
+	w.WriteStatusLine(response.StatusNoContent)
+	w.Header.Set("Allow", strings.Join(allow, ", "))
+	w.Header.Del("Content-Length")
+	w.WriteHeaders(w.Header)
+}
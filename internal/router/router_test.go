@@ -0,0 +1,114 @@
+package router
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sunilpar/My-Own-Http-Server/internal/request"
+	"github.com/sunilpar/My-Own-Http-Server/internal/response"
+	"github.com/sunilpar/My-Own-Http-Server/internal/server"
+	"github.com/sunilpar/My-Own-Http-Server/internal/testutil"
+)
+
+func text(body string) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		response.WriteText(w, response.StatusOK, body)
+	}
+}
+
+func TestPathParameters(t *testing.T) {
+	r := New()
+	r.Get("/users/{id}", func(w *response.Writer, req *request.Request) {
+		response.WriteText(w, response.StatusOK, "user "+req.PathValue("id"))
+	})
+	r.Get("/files/{path...}", func(w *response.Writer, req *request.Request) {
+		response.WriteText(w, response.StatusOK, "file "+req.PathValue("path"))
+	})
+
+	assert.True(t, strings.HasSuffix(testutil.Serve(t, r.ServeRequest, "GET /users/42 HTTP/1.1\r\nHost: a\r\n\r\n"), "user 42"))
+	assert.True(t, strings.HasSuffix(testutil.Serve(t, r.ServeRequest, "GET /files/css/site.css HTTP/1.1\r\nHost: a\r\n\r\n"), "file css/site.css"))
+	assert.True(t, strings.HasPrefix(testutil.Serve(t, r.ServeRequest, "GET /users/42/extra HTTP/1.1\r\nHost: a\r\n\r\n"), "HTTP/1.1 404 Not Found"))
+	assert.True(t, strings.HasPrefix(testutil.Serve(t, r.ServeRequest, "GET /users/ HTTP/1.1\r\nHost: a\r\n\r\n"), "HTTP/1.1 404 Not Found"))
+}
+
+func TestMostSpecificRouteWins(t *testing.T) {
+	r := New()
+	r.Get("/{path...}", text("catch-all"))
+	r.Get("/users/{id}", text("param"))
+	r.Get("/users/me", text("literal"))
+	r.Get("example.com/users/me", text("host"))
+
+	assert.True(t, strings.HasSuffix(testutil.Serve(t, r.ServeRequest, "GET /users/me HTTP/1.1\r\nHost: a\r\n\r\n"), "literal"))
+	assert.True(t, strings.HasSuffix(testutil.Serve(t, r.ServeRequest, "GET /users/7 HTTP/1.1\r\nHost: a\r\n\r\n"), "param"))
+	assert.True(t, strings.HasSuffix(testutil.Serve(t, r.ServeRequest, "GET /other HTTP/1.1\r\nHost: a\r\n\r\n"), "catch-all"))
+	assert.True(t, strings.HasSuffix(testutil.Serve(t, r.ServeRequest, "GET /users/me HTTP/1.1\r\nHost: Example.com:42069\r\n\r\n"), "host"))
+}
+
+func TestMethodNotAllowedAndOptions(t *testing.T) {
+	r := New()
+	r.Get("/items", text("list"))
+	r.Post("/items", text("create"))
+
+	resp := testutil.Serve(t, r.ServeRequest, "DELETE /items HTTP/1.1\r\nHost: a\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 405 Method Not Allowed"))
+	assert.Contains(t, resp, "allow: GET, HEAD, OPTIONS, POST\r\n")
+
+	resp = testutil.Serve(t, r.ServeRequest, "OPTIONS /items HTTP/1.1\r\nHost: a\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 204 No Content"))
+	assert.Contains(t, resp, "allow: GET, HEAD, OPTIONS, POST\r\n")
+
+	resp = testutil.Serve(t, r.ServeRequest, "OPTIONS * HTTP/1.1\r\nHost: a\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 204 No Content"))
+}
+
+func TestHeadMatchesGetRoutes(t *testing.T) {
+	r := New()
+	r.Get("/page", text("page"))
+	r.Get("/special", text("get"))
+	r.Handle("HEAD", "/special", text("head"))
+	r.Post("/form", text("form"))
+
+	assert.True(t, strings.HasPrefix(testutil.Serve(t, r.ServeRequest, "HEAD /page HTTP/1.1\r\nHost: a\r\n\r\n"), "HTTP/1.1 200 OK"))
+	assert.True(t, strings.HasSuffix(testutil.Serve(t, r.ServeRequest, "HEAD /special HTTP/1.1\r\nHost: a\r\n\r\n"), "head"))
+	assert.True(t, strings.HasSuffix(testutil.Serve(t, r.ServeRequest, "GET /special HTTP/1.1\r\nHost: a\r\n\r\n"), "get"))
+
+	resp := testutil.Serve(t, r.ServeRequest, "HEAD /form HTTP/1.1\r\nHost: a\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 405 Method Not Allowed"))
+	assert.Contains(t, resp, "allow: OPTIONS, POST\r\n")
+}
+
+func TestGroupsApplyPrefixAndMiddleware(t *testing.T) {
+	var calls []string
+	tag := func(name string) Middleware {
+		return func(next server.Handler) server.Handler {
+			return func(w *response.Writer, req *request.Request) {
+				calls = append(calls, name)
+				next(w, req)
+			}
+		}
+	}
+
+	r := New()
+	api := r.Group("/api", tag("api"))
+	v1 := api.Group("/v1/", tag("v1"))
+	v1.Get("/ping", text("pong"))
+	r.Get("/ping", text("root"))
+
+	assert.True(t, strings.HasSuffix(testutil.Serve(t, r.ServeRequest, "GET /api/v1/ping HTTP/1.1\r\nHost: a\r\n\r\n"), "pong"))
+	assert.Equal(t, []string{"api", "v1"}, calls)
+
+	calls = nil
+	assert.True(t, strings.HasSuffix(testutil.Serve(t, r.ServeRequest, "GET /ping HTTP/1.1\r\nHost: a\r\n\r\n"), "root"))
+	assert.Empty(t, calls)
+}
+
+func TestInvalidPatternsPanic(t *testing.T) {
+	r := New()
+	assert.Panics(t, func() { r.Get("/files/{path...}/more", text("x")) })
+	assert.Panics(t, func() { r.Get("/a/{id}/{id}", text("x")) })
+	assert.Panics(t, func() { r.Get("no-slash", text("x")) })
+	r.Get("/dup", text("x"))
+	assert.Panics(t, func() { r.Get("/dup", text("x")) })
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var aLongTimeAgo = time.Unix(1, 0)
+
+// connReader wraps a connection so that, while a handler runs, a single byte
+// read can sit on the socket to notice the client going away. A byte that
+// arrives during that read is kept and handed to the next Read.
+type connReader struct {
+	conn net.Conn
+
+	mu       sync.Mutex
+	hasByte  bool
+	byteBuf  [1]byte
+	sawEOF   bool
+	bgDone   chan struct{}
+	aborting atomic.Bool
+
+	// minimum read rate for the request being read, see beginRequest
+	rate      MinDataRate
+	rateStart time.Time
+	received  int64
+}
+
+func newConnReader(conn net.Conn, rate MinDataRate) *connReader {
+	return &connReader{conn: conn, rate: rate}
+}
+
+// beginRequest starts counting the bytes of the next request. The rate
+// clock starts with the first byte.
+func (cr *connReader) beginRequest() {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	cr.rateStart = time.Time{}
+	cr.received = 0
+}
+
+// endRequest clears any read deadline and reports how many bytes arrived
+// since beginRequest.
+func (cr *connReader) endRequest() int64 {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	cr.conn.SetReadDeadline(time.Time{})
+	return cr.received
+}
+
+func (cr *connReader) count(n int) {
+	if n == 0 {
+		return
+	}
+	if cr.rateStart.IsZero() {
+		cr.rateStart = time.Now()
+	}
+	cr.received += int64(n)
+}
+
+func (cr *connReader) Read(p []byte) (int, error) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if cr.hasByte {
+		p[0] = cr.byteBuf[0]
+		cr.hasByte = false
+		cr.count(1)
+		return 1, nil
+	}
+	measured := cr.rate.enabled() && !cr.rateStart.IsZero()
+	if measured {
+		cr.conn.SetReadDeadline(cr.rateStart.Add(cr.rate.allowance(cr.received)))
+	}
+	n, err := cr.conn.Read(p)
+	cr.count(n)
+	if err == io.EOF {
+		cr.sawEOF = true
+	}
+	if measured && errors.Is(err, os.ErrDeadlineExceeded) {
+		err = fmt.Errorf("%w: %w", ErrSlowClient, err)
+	}
+	return n, err
+}
+
+// startBackgroundRead calls onClose if the peer closes the connection or it
+// fails before abortPendingRead is called.
+func (cr *connReader) startBackgroundRead(onClose func()) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	if cr.sawEOF || cr.hasByte || cr.bgDone != nil {
+		return
+	}
+	cr.bgDone = make(chan struct{})
+	go func() {
+		defer close(cr.bgDone)
+		n, err := cr.conn.Read(cr.byteBuf[:])
+		if n == 1 {
+			cr.hasByte = true
+		}
+		if err == nil {
+			return
+		}
+		if cr.aborting.Load() && errors.Is(err, os.ErrDeadlineExceeded) {
+			return
+		}
+		if err == io.EOF {
+			cr.sawEOF = true
+		}
+		onClose()
+	}()
+}
+
+func (cr *connReader) abortPendingRead() {
+	cr.mu.Lock()
+	done := cr.bgDone
+	cr.mu.Unlock()
+	if done == nil {
+		return
+	}
+	cr.aborting.Store(true)
+	cr.conn.SetReadDeadline(aLongTimeAgo)
+	<-done
+	cr.conn.SetReadDeadline(time.Time{})
+	cr.aborting.Store(false)
+
+	cr.mu.Lock()
+	cr.bgDone = nil
+	cr.mu.Unlock()
+}
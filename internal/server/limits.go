@@ -0,0 +1,154 @@
+package server
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sunilpar/My-Own-Http-Server/internal/response"
+)
+
+// Limits bound the resources a flood of clients can take. Zero values mean
+// no limit.
+type Limits struct {
+	// MaxConns caps open connections; once reached the server stops
+	// accepting until one closes, leaving new clients in the listen backlog.
+	MaxConns int
+	// MaxConnsPerIP caps open connections from one remote IP. Connections
+	// over the cap get a 503 and are closed.
+	MaxConnsPerIP int
+	// MaxConcurrentRequests caps handlers running at once. Up to QueueSize
+	// further requests wait at most QueueTimeout for a slot before being
+	// answered with a 503.
+	MaxConcurrentRequests int
+	QueueSize             int
+	QueueTimeout          time.Duration
+	// RetryAfter is sent with every 503; it defaults to one second.
+	RetryAfter time.Duration
+}
+
+func WithLimits(l Limits) Option {
+	return func(s *Server) {
+		s.limits = l
+	}
+}
+
+type limiter struct {
+	conns chan struct{}
+
+	mu    sync.Mutex
+	perIP map[string]int
+
+	requests chan struct{}
+	queued   atomic.Int64
+}
+
+func newLimiter(l Limits) *limiter {
+	lim := &limiter{perIP: make(map[string]int)}
+	if l.MaxConns > 0 {
+		lim.conns = make(chan struct{}, l.MaxConns)
+	}
+	if l.MaxConcurrentRequests > 0 {
+		lim.requests = make(chan struct{}, l.MaxConcurrentRequests)
+	}
+	return lim
+}
+
+// acquireConn blocks until a connection slot is free or ctx is done.
+func (s *Server) acquireConn(ctx context.Context) bool {
+	if s.lim.conns == nil {
+		return true
+	}
+	select {
+	case s.lim.conns <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (s *Server) releaseConn() {
+	if s.lim.conns != nil {
+		<-s.lim.conns
+	}
+}
+
+func (s *Server) acquireIP(addr net.Addr) (string, bool) {
+	if s.limits.MaxConnsPerIP <= 0 {
+		return "", true
+	}
+	ip := addr.String()
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	s.lim.mu.Lock()
+	defer s.lim.mu.Unlock()
+	if s.lim.perIP[ip] >= s.limits.MaxConnsPerIP {
+		return "", false
+	}
+	s.lim.perIP[ip]++
+	return ip, true
+}
+
+func (s *Server) releaseIP(ip string) {
+	if ip == "" {
+		return
+	}
+	s.lim.mu.Lock()
+	defer s.lim.mu.Unlock()
+	if s.lim.perIP[ip]--; s.lim.perIP[ip] <= 0 {
+		delete(s.lim.perIP, ip)
+	}
+}
+
+// acquireRequest waits in the bounded queue for a handler slot. It gives up
+// straight away when the queue is full and after QueueTimeout otherwise.
+func (s *Server) acquireRequest(ctx context.Context) bool {
+	if s.lim.requests == nil {
+		return true
+	}
+	select {
+	case s.lim.requests <- struct{}{}:
+		return true
+	default:
+	}
+	if s.lim.queued.Add(1) > int64(s.limits.QueueSize) {
+		s.lim.queued.Add(-1)
+		return false
+	}
+	defer s.lim.queued.Add(-1)
+
+	var timeout <-chan time.Time
+	if s.limits.QueueTimeout > 0 {
+		timer := time.NewTimer(s.limits.QueueTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case s.lim.requests <- struct{}{}:
+		return true
+	case <-timeout:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (s *Server) releaseRequest() {
+	if s.lim.requests != nil {
+		<-s.lim.requests
+	}
+}
+
+func (s *Server) writeOverloaded(w *response.Writer) {
+	retry := s.limits.RetryAfter
+	if retry <= 0 {
+		retry = time.Second
+	}
+	w.Header.Set("Retry-After", strconv.Itoa(int((retry+time.Second-1)/time.Second)))
+	w.Header.Set("Connection", "close")
+	response.WriteText(w, response.StatusServiceUnavailable, "Service Unavailable")
+}
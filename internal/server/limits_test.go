@@ -0,0 +1,177 @@
+package server_test
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunilpar/My-Own-Http-Server/internal/request"
+	"github.com/sunilpar/My-Own-Http-Server/internal/response"
+	"github.com/sunilpar/My-Own-Http-Server/internal/server"
+)
+
+// blockingHandler signals on started and waits for release before replying.
+func blockingHandler(started chan<- struct{}, release <-chan struct{}) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		started <- struct{}{}
+		<-release
+		okHandler(w, req)
+	}
+}
+
+func startRequest(t *testing.T, addr string) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	_, err = fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	return conn, bufio.NewReader(conn)
+}
+
+func TestRequestQueueFullReturns503(t *testing.T) {
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	s, err := server.Serve(0, blockingHandler(started, release), server.WithLimits(server.Limits{
+		MaxConcurrentRequests: 1,
+		RetryAfter:            3 * time.Second,
+	}))
+	require.NoError(t, err)
+	defer s.Close()
+
+	first, firstResp := startRequest(t, s.Addr().String())
+	defer first.Close()
+	<-started
+
+	second, secondResp := startRequest(t, s.Addr().String())
+	defer second.Close()
+	status, err := secondResp.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 503 Service Unavailable\r\n", status)
+	resp := readHeaders(t, secondResp)
+	assert.Contains(t, resp, "retry-after: 3\r\n")
+
+	close(release)
+	status, err = firstResp.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", status)
+}
+
+func TestRequestQueueWaitsForSlot(t *testing.T) {
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	s, err := server.Serve(0, blockingHandler(started, release), server.WithLimits(server.Limits{
+		MaxConcurrentRequests: 1,
+		QueueSize:             1,
+		QueueTimeout:          time.Second,
+	}))
+	require.NoError(t, err)
+	defer s.Close()
+
+	first, _ := startRequest(t, s.Addr().String())
+	defer first.Close()
+	<-started
+	second, secondResp := startRequest(t, s.Addr().String())
+	defer second.Close()
+
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	<-started
+	status, err := secondResp.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", status)
+}
+
+func TestPerIPConnectionCap(t *testing.T) {
+	s, err := server.Serve(0, okHandler, server.WithLimits(server.Limits{MaxConnsPerIP: 1}))
+	require.NoError(t, err)
+	defer s.Close()
+
+	first, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer first.Close()
+	time.Sleep(50 * time.Millisecond)
+
+	second, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer second.Close()
+	status, err := bufio.NewReader(second).ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 503 Service Unavailable\r\n", status)
+
+	first.Close()
+	time.Sleep(50 * time.Millisecond)
+	resp, err := sendRawRequest(t, s.Addr().String(), "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", resp)
+}
+
+func TestGlobalConnectionCapDefersAccept(t *testing.T) {
+	s, err := server.Serve(0, okHandler, server.WithLimits(server.Limits{MaxConns: 1}))
+	require.NoError(t, err)
+	defer s.Close()
+
+	first, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer first.Close()
+	time.Sleep(50 * time.Millisecond)
+
+	second, secondResp := startRequest(t, s.Addr().String())
+	defer second.Close()
+	second.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	_, err = secondResp.ReadString('\n')
+	assert.Error(t, err, "second connection served while the first holds the only slot")
+
+	first.Close()
+	second.SetReadDeadline(time.Now().Add(time.Second))
+	status, err := secondResp.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", status)
+}
+
+// flakyListener fails its first few Accepts with an error that is not
+// marked temporary.
+type flakyListener struct {
+	net.Listener
+	failures atomic.Int32
+}
+
+func (l *flakyListener) Accept() (net.Conn, error) {
+	if l.failures.Add(-1) >= 0 {
+		return nil, errors.New("accept failed")
+	}
+	return l.Listener.Accept()
+}
+
+func TestAcceptErrorsAreRetried(t *testing.T) {
+	s, err := server.Serve(0, okHandler, server.WithListenerWrapper(func(ln net.Listener) net.Listener {
+		fl := &flakyListener{Listener: ln}
+		fl.failures.Store(3)
+		return fl
+	}))
+	require.NoError(t, err)
+	defer s.Close()
+
+	resp, err := sendRawRequest(t, s.Addr().String(), "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", resp)
+}
+
+func readHeaders(t *testing.T, r *bufio.Reader) string {
+	t.Helper()
+	var headers string
+	for {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		if line == "\r\n" {
+			return headers
+		}
+		headers += line
+	}
+}
@@ -0,0 +1,24 @@
+package server
+
+// Middleware wraps a Handler with behaviour that runs before and after it.
+type Middleware func(Handler) Handler
+
+// Chain is an ordered list of middleware; the first one is outermost.
+type Chain []Middleware
+
+func NewChain(mw ...Middleware) Chain {
+	return append(Chain(nil), mw...)
+}
+
+// Append returns a new chain with mw added after the existing middleware,
+// leaving c untouched.
+func (c Chain) Append(mw ...Middleware) Chain {
+	return append(append(Chain(nil), c...), mw...)
+}
+
+func (c Chain) Then(h Handler) Handler {
+	for i := len(c) - 1; i >= 0; i-- {
+		h = c[i](h)
+	}
+	return h
+}
@@ -0,0 +1,67 @@
+package server_test
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sunilpar/My-Own-Http-Server/internal/request"
+	"github.com/sunilpar/My-Own-Http-Server/internal/response"
+	"github.com/sunilpar/My-Own-Http-Server/internal/server"
+)
+
+func record(name string, calls *[]string) server.Middleware {
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			*calls = append(*calls, name+" before")
+			next(w, req)
+			*calls = append(*calls, name+" after")
+		}
+	}
+}
+
+func TestChainOrder(t *testing.T) {
+	var calls []string
+	base := server.NewChain(record("outer", &calls))
+	extended := base.Append(record("inner", &calls))
+
+	h := extended.Then(func(w *response.Writer, req *request.Request) {
+		calls = append(calls, "handler")
+	})
+	h(nil, nil)
+	assert.Equal(t, []string{"outer before", "inner before", "handler", "inner after", "outer after"}, calls)
+
+	calls = nil
+	base.Then(func(w *response.Writer, req *request.Request) {})(nil, nil)
+	assert.Equal(t, []string{"outer before", "outer after"}, calls)
+}
+
+func TestMiddlewareSeesResponseOutcome(t *testing.T) {
+	var status response.StatusCode
+	var body, wire int64
+	observe := func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			next(w, req)
+			status, body, wire = w.Status(), w.BytesWritten(), w.WireBytes()
+		}
+	}
+
+	conn, client := net.Pipe()
+	go io.Copy(io.Discard, client)
+	server.NewChain(observe).Then(func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusOK)
+		w.Header.Set("Transfer-Encoding", "chunked")
+		w.WriteHeaders(w.Header)
+		w.WriteChunkedBody([]byte("hello "))
+		w.WriteChunkedBody([]byte("world"))
+		w.WriteChunkedBodyDone()
+	})(response.NewWriter(conn), nil)
+	conn.Close()
+
+	assert.Equal(t, response.StatusOK, status)
+	assert.Equal(t, int64(11), body)
+	// status line, header block and chunk framing are counted on the wire
+	assert.Equal(t, int64(17+28+2+(3+6+2)+(3+5+2)+3), wire)
+}
@@ -0,0 +1,31 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
+
+const RequestIDHeader = "X-Request-ID"
+
+// requestID returns the client-supplied ID when it is safe to echo back,
+// otherwise a fresh random one.
+func requestID(incoming string) string {
+	if validRequestID(incoming) {
+		return incoming
+	}
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] >= 0x7f {
+			return false
+		}
+	}
+	return true
+}
@@ -1,74 +1,473 @@
 package server
 
 import (
-	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
+	"runtime/debug"
+	"strings"
+	"sync"
 	"sync/atomic"
+	"time"
 
+	"github.com/sunilpar/My-Own-Http-Server/internal/headers"
 	"github.com/sunilpar/My-Own-Http-Server/internal/request"
 	"github.com/sunilpar/My-Own-Http-Server/internal/response"
 )
 
 type Handler func(w *response.Writer, req *request.Request)
 
+type Option func(*Server)
+
+// PanicHook is called with the recovered value and stack trace after a
+// handler panics.
+type PanicHook func(req *request.Request, recovered any, stack []byte)
+
+type ConnState int
+
+const (
+	// StateNew is a freshly accepted connection yet to send a request.
+	StateNew ConnState = iota
+	// StateActive is a connection whose request is being handled.
+	StateActive
+	// StateIdle is a kept-alive connection waiting for its next request.
+	StateIdle
+	StateClosed
+)
+
+func (c ConnState) String() string {
+	switch c {
+	case StateNew:
+		return "new"
+	case StateActive:
+		return "active"
+	case StateIdle:
+		return "idle"
+	default:
+		return "closed"
+	}
+}
+
 type Server struct {
-	listener net.Listener
-	closed   atomic.Bool
-	handler  Handler
+	listener       net.Listener
+	closed         atomic.Bool
+	handler        Handler
+	handlerTimeout time.Duration
+	panicHook      PanicHook
+	idleTimeout    time.Duration
+	tlsConfig      *tls.Config
+	wrapListener   func(net.Listener) net.Listener
+	pathOptions    request.PathOptions
+	streamBody     func(*request.Request) bool
+	trustedProxies request.TrustedProxies
+	connStateHook  func(net.Conn, ConnState)
+	parseErrorHook func(error)
+	slowClientHook func(net.Conn, string)
+	minReadRate    MinDataRate
+	minWriteRate   MinDataRate
+	limits         Limits
+	lim            *limiter
+	baseCtx        context.Context
+	cancel         context.CancelFunc
+	nextConnID     atomic.Uint64
+
+	mu    sync.Mutex
+	conns map[net.Conn]ConnState
+}
+
+// WithHandlerTimeout sets a deadline on every request's context.
+func WithHandlerTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.handlerTimeout = d
+	}
+}
+
+// WithIdleTimeout limits how long a kept-alive connection may wait for its
+// next request.
+func WithIdleTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.idleTimeout = d
+	}
+}
+
+// WithTLSConfig serves HTTPS on the listener using cfg.
+func WithTLSConfig(cfg *tls.Config) Option {
+	return func(s *Server) {
+		s.tlsConfig = cfg
+	}
+}
+
+// WithListenerWrapper lets wrap replace the TCP listener, for example with
+// one that reads PROXY protocol headers. TLS, if configured, is layered on
+// top of the wrapped listener.
+func WithListenerWrapper(wrap func(net.Listener) net.Listener) Option {
+	return func(s *Server) {
+		s.wrapListener = wrap
+	}
+}
+
+// WithPathOptions controls how request paths are normalized and which
+// encoded characters are rejected.
+func WithPathOptions(opts request.PathOptions) Option {
+	return func(s *Server) {
+		s.pathOptions = opts
+	}
+}
+
+// WithStreamedBodies leaves the body of every request stream accepts on
+// the connection for the handler to read from Request.BodyReader, instead
+// of reading it into Request.Body first. stream sees the request line and
+// headers only.
+func WithStreamedBodies(stream func(*request.Request) bool) Option {
+	return func(s *Server) {
+		s.streamBody = stream
+	}
 }
 
-func Serve(port int, handler Handler) (*Server, error) {
+// WithTrustedProxies sets the proxies whose forwarding headers are believed
+// when filling in Request.Origin, and which header that is. Those headers
+// are ignored on connections from anywhere else.
+func WithTrustedProxies(p request.TrustedProxies) Option {
+	return func(s *Server) {
+		s.trustedProxies = p
+	}
+}
+
+// WithConnStateHook calls hook whenever a connection changes state.
+func WithConnStateHook(hook func(net.Conn, ConnState)) Option {
+	return func(s *Server) {
+		s.connStateHook = hook
+	}
+}
+
+// WithParseErrorHook calls hook with every error that made the server reject
+// a request before it reached the handler.
+func WithParseErrorHook(hook func(error)) Option {
+	return func(s *Server) {
+		s.parseErrorHook = hook
+	}
+}
+
+func WithPanicHook(hook PanicHook) Option {
+	return func(s *Server) {
+		s.panicHook = hook
+	}
+}
+
+func Serve(port int, handler Handler, opts ...Option) (*Server, error) {
 	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
 	if err != nil {
 		return nil, fmt.Errorf("failed to listen on port %d: %w", port, err)
 	}
+	ctx, cancel := context.WithCancel(context.Background())
 	s := &Server{
-		listener: ln,
-		handler:  handler,
+		listener:     ln,
+		handler:      handler,
+		baseCtx:      ctx,
+		cancel:       cancel,
+		idleTimeout:  2 * time.Minute,
+		pathOptions:  request.DefaultPathOptions,
+		minReadRate:  DefaultMinDataRate,
+		minWriteRate: DefaultMinDataRate,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.wrapListener != nil {
+		s.listener = s.wrapListener(s.listener)
 	}
+	if s.tlsConfig != nil {
+		s.listener = tls.NewListener(s.listener, s.tlsConfig)
+	}
+	s.lim = newLimiter(s.limits)
 	go s.listen()
 	return s, nil
 }
 
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Close stops accepting connections and closes the ones waiting for a
+// request. Requests in progress have their context cancelled, and their
+// connections are closed once they are answered.
 func (s *Server) Close() error {
 	if s.closed.CompareAndSwap(false, true) {
-		return s.listener.Close()
+		s.cancel()
+		err := s.listener.Close()
+		s.mu.Lock()
+		for conn, state := range s.conns {
+			if state != StateActive {
+				conn.Close()
+			}
+		}
+		s.mu.Unlock()
+		return err
 	}
 	return nil
 }
 
 func (s *Server) listen() {
+	var backoff time.Duration
 	for {
+		if !s.acquireConn(s.baseCtx) {
+			return
+		}
 		conn, err := s.listener.Accept()
 		if err != nil {
+			s.releaseConn()
 			if s.closed.Load() {
 				return
 			}
-			log.Printf("Error accepting connection: %v\n", err)
+			if errors.Is(err, net.ErrClosed) {
+				log.Printf("Listener closed, no longer accepting connections: %v\n", err)
+				return
+			}
+			// Running out of file descriptors or a client aborting before
+			// it was accepted must not stop the server, and an error from a
+			// wrapped listener cannot be assumed permanent, so anything
+			// else is retried.
+			if backoff == 0 {
+				backoff = 5 * time.Millisecond
+			} else if backoff *= 2; backoff > time.Second {
+				backoff = time.Second
+			}
+			log.Printf("Error accepting connection: %v; retrying in %v\n", err, backoff)
+			time.Sleep(backoff)
 			continue
 		}
+		backoff = 0
 		go s.handle(conn)
 	}
 }
 
+func (s *Server) setState(conn net.Conn, state ConnState) {
+	s.mu.Lock()
+	switch {
+	case state == StateClosed:
+		delete(s.conns, conn)
+	case state != StateActive && s.closed.Load():
+		// Close has already been through the connections
+		conn.Close()
+	default:
+		if s.conns == nil {
+			s.conns = make(map[net.Conn]ConnState)
+		}
+		s.conns[conn] = state
+	}
+	s.mu.Unlock()
+	if s.connStateHook != nil {
+		s.connStateHook(conn, state)
+	}
+}
+
 func (s *Server) handle(conn net.Conn) {
+	defer s.releaseConn()
 	defer conn.Close()
+	s.setState(conn, StateNew)
+	defer s.setState(conn, StateClosed)
 
-	req, err := request.RequestFromReader(bufio.NewReader(conn))
-	if err != nil {
-		log.Printf("Malformed request: %v\n", err)
-		w := response.NewWriter(conn)
-		w.WriteStatusLine(response.StatusBadRequest)
-		w.Header.Set("Content-Type", "text/plain")
-		body := []byte("Bad Request")
-		w.Header.Set("Content-Length", fmt.Sprintf("%d", len(body)+2))
-		w.WriteHeaders(w.Header)
-		w.WriteBody(body)
+	ip, ok := s.acquireIP(conn.RemoteAddr())
+	if !ok {
+		s.writeOverloaded(response.NewWriter(conn))
 		return
 	}
+	defer s.releaseIP(ip)
+
+	accepted := time.Now()
+	connID := s.nextConnID.Add(1)
+	var tlsState *tls.ConnectionState
+	if tc, ok := conn.(*tls.Conn); ok {
+		if s.minReadRate.enabled() {
+			conn.SetDeadline(accepted.Add(s.minReadRate.Grace))
+		}
+		err := tc.Handshake()
+		conn.SetDeadline(time.Time{})
+		if err != nil {
+			log.Printf("TLS handshake error from %s: %v\n", conn.RemoteAddr(), err)
+			return
+		}
+		state := tc.ConnectionState()
+		tlsState = &state
+	}
+
+	cr := newConnReader(conn, s.minReadRate)
+	rr := request.NewReader(cr)
+	rr.PathOptions = s.pathOptions
+	rr.StreamBody = s.streamBody
+	for served := 1; ; served++ {
+		// Until a request starts, the connection is bound by the idle
+		// timeout, so that one opened ahead of time is not taken for a slow
+		// client. The rate clock starts with the request's first byte.
+		if served > 1 {
+			s.setState(conn, StateIdle)
+		}
+		if s.idleTimeout > 0 {
+			conn.SetReadDeadline(time.Now().Add(s.idleTimeout))
+		}
+		cr.beginRequest()
+		req, err := rr.ReadRequest()
+		received := cr.endRequest()
+		if err != nil {
+			// A client going away, or timing out, before it sends a
+			// request is the normal end of a connection.
+			var perr *request.ParseError
+			if err == io.EOF || (received == 0 && !errors.As(err, &perr)) || s.closed.Load() {
+				return
+			}
+			if errors.Is(err, ErrSlowClient) {
+				s.reportSlowClient(conn, "read")
+				return
+			}
+			if s.parseErrorHook != nil {
+				s.parseErrorHook(err)
+			}
+			log.Printf("Malformed request: %v\n", err)
+			// the connection is closed either way, since where this request
+			// ends is unknown
+			w := response.NewWriter(conn)
+			w.Header.Set("Connection", "close")
+			if errors.Is(err, request.ErrUnsupportedTransferEncoding) {
+				response.WriteText(w, response.StatusNotImplemented, "Not Implemented")
+			} else {
+				response.WriteText(w, response.StatusBadRequest, "Bad Request")
+			}
+			closeWriteAndDrain(conn)
+			return
+		}
+		s.setState(conn, StateActive)
+
+		req.RemoteAddr = conn.RemoteAddr()
+		req.LocalAddr = conn.LocalAddr()
+		req.TLS = tlsState
+		req.ConnID = connID
+		req.ConnRequests = served
+		req.Origin = request.ResolveOrigin(req, s.trustedProxies)
+
+		if !s.serveRequest(conn, cr, req) || s.closed.Load() {
+			return
+		}
+	}
+}
+
+// serveRequest runs the handler for one request and reports whether the
+// connection may be kept open for the next one.
+func (s *Server) serveRequest(conn net.Conn, cr *connReader, req *request.Request) (keepAlive bool) {
+	ctx, cancel := context.WithCancel(s.baseCtx)
+	defer cancel()
+	if s.handlerTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, s.handlerTimeout)
+		defer cancel()
+	}
+	id := requestID(req.Headers.Get(RequestIDHeader))
+	ctx = request.ContextWithID(ctx, id)
+	ctx = request.ContextWithRemoteAddr(ctx, req.RemoteAddr)
+	req = req.WithContext(ctx)
+
+	if req.BodyReader == nil {
+		cr.startBackgroundRead(cancel)
+		defer cr.abortPendingRead()
+	} else {
+		// the handler reads the rest of the request itself, and is held to
+		// the read rate from its first byte
+		cr.beginRequest()
+		defer cr.endRequest()
+	}
 
-	respWriter := response.NewWriter(conn)
-	s.handler(respWriter, req)
+	rc := newRateConn(conn, s.minWriteRate)
+	defer rc.done()
+	w := response.NewWriter(rc)
+	if req.RequestLine.Method == "HEAD" {
+		w.DiscardBody()
+	}
+	w.Header.Set(RequestIDHeader, id)
+	if !s.acquireRequest(ctx) {
+		s.writeOverloaded(w)
+		return false
+	}
+	defer s.releaseRequest()
+	defer func() {
+		if v := recover(); v != nil {
+			s.handlePanic(conn, w, req, v)
+			keepAlive = false
+		}
+	}()
+	s.handler(w, req)
+	if rc.tooSlow {
+		s.reportSlowClient(conn, "write")
+		return false
+	}
+
+	keepAlive = w.Finish() && !hasToken(req.Headers.Get("Connection"), "close")
+	if keepAlive && req.BodyReader != nil {
+		// the next request starts where this body ends; a large unread
+		// remainder is not worth waiting for
+		n, err := io.Copy(io.Discard, io.LimitReader(req.BodyReader, maxBodyDrain+1))
+		keepAlive = err == nil && n <= maxBodyDrain
+	}
+	return keepAlive
+}
+
+// maxBodyDrain is how much of a streamed body the handler left unread is
+// discarded to keep the connection open.
+const maxBodyDrain = 256 << 10
+
+func (s *Server) handlePanic(conn net.Conn, w *response.Writer, req *request.Request, v any) {
+	stack := debug.Stack()
+	log.Printf("Panic serving %s %s for %s (request %s): %v\n%s",
+		req.RequestLine.Method, req.RequestLine.RequestTarget, req.RemoteAddr,
+		req.ID(), v, stack)
+	if s.panicHook != nil {
+		s.panicHook(req, v, stack)
+	}
+
+	if !w.StatusWritten() {
+		// whatever the handler set was meant for a response it never sent
+		id := w.Header.Get(RequestIDHeader)
+		w.Header = headers.NewHeaders()
+		w.Header.Set(RequestIDHeader, id)
+		w.Header.Set("Connection", "close")
+		response.WriteText(w, response.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	// Part of the response is already on the wire, so reset the connection
+	// rather than let the client mistake a truncated body for a complete one.
+	if tc, ok := netConn(conn).(*net.TCPConn); ok {
+		tc.SetLinger(0)
+	}
+}
+
+// netConn unwraps conn down to the connection accepted from the network.
+func netConn(conn net.Conn) net.Conn {
+	for {
+		w, ok := conn.(interface{ NetConn() net.Conn })
+		if !ok {
+			return conn
+		}
+		conn = w.NetConn()
+	}
+}
+
+func hasToken(header, token string) bool {
+	for _, t := range strings.Split(header, ",") {
+		if strings.EqualFold(strings.TrimSpace(t), token) {
+			return true
+		}
+	}
+	return false
+}
+
+// closeWriteAndDrain lets the client read an error response sent before
+// the whole request was. Closing with its bytes still unread would reset
+// the connection, which can discard the response on the client's side.
+func closeWriteAndDrain(conn net.Conn) {
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+	}
+	conn.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+	io.Copy(io.Discard, io.LimitReader(conn, 256<<10))
 }
@@ -3,15 +3,30 @@ package server_test
 import (
 	"bufio"
 	"fmt"
-	"httpfromtcp/internal/server"
+	"io"
 	"net"
+	"net/netip"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunilpar/My-Own-Http-Server/internal/proxyproto"
+	"github.com/sunilpar/My-Own-Http-Server/internal/request"
+	"github.com/sunilpar/My-Own-Http-Server/internal/response"
+	"github.com/sunilpar/My-Own-Http-Server/internal/server"
 )
 
+func okHandler(w *response.Writer, req *request.Request) {
+	body := "ok"
+	w.WriteStatusLine(response.StatusOK)
+	w.Header.Set("Content-Length", fmt.Sprintf("%d", len(body)))
+	w.WriteHeaders(w.Header)
+	w.WriteBody([]byte(body))
+}
+
 func sendRawRequest(t *testing.T, address string, raw string) (string, error) {
 	conn, err := net.Dial("tcp", address)
 	if err != nil {
@@ -33,29 +48,518 @@ func sendRawRequest(t *testing.T, address string, raw string) (string, error) {
 }
 
 func TestValidRequestReturns200(t *testing.T) {
-	s, err := server.Serve(42069)
+	s, err := server.Serve(0, okHandler)
 	assert.NoError(t, err)
 	defer s.Close()
 
-	time.Sleep(100 * time.Millisecond) // Give the server time to start
-
 	raw := "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"
-	resp, err := sendRawRequest(t, "localhost:42069", raw)
+	resp, err := sendRawRequest(t, s.Addr().String(), raw)
 	assert.NoError(t, err)
 	assert.True(t, strings.Contains(resp, "200 OK"))
 }
 
 func TestMalformedHeaderRequest(t *testing.T) {
-	s, err := server.Serve(42069)
+	s, err := server.Serve(0, okHandler)
 	assert.NoError(t, err)
 	defer s.Close()
 
-	time.Sleep(100 * time.Millisecond) // Give the server time to start
-
 	// Send malformed header (missing colon)
 	raw := "GET / HTTP/1.1\r\nInvalidHeader\r\n\r\n"
-	_, err = sendRawRequest(t, "localhost:42069", raw)
+	resp, err := sendRawRequest(t, s.Addr().String(), raw)
+	assert.NoError(t, err)
+	assert.True(t, strings.Contains(resp, "400 Bad Request"))
+}
+
+func TestContextCancelledOnClientDisconnect(t *testing.T) {
+	cancelled := make(chan error, 1)
+	s, err := server.Serve(0, func(w *response.Writer, req *request.Request) {
+		select {
+		case <-req.Context().Done():
+			cancelled <- req.Context().Err()
+		case <-time.After(5 * time.Second):
+			cancelled <- nil
+		}
+	})
+	require.NoError(t, err)
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	_, err = fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	time.Sleep(50 * time.Millisecond)
+	conn.Close()
+
+	assert.Error(t, <-cancelled)
+}
+
+func TestContextCancelledOnServerClose(t *testing.T) {
+	started := make(chan struct{})
+	cancelled := make(chan error, 1)
+	s, err := server.Serve(0, func(w *response.Writer, req *request.Request) {
+		close(started)
+		<-req.Context().Done()
+		cancelled <- req.Context().Err()
+	})
+	require.NoError(t, err)
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+
+	<-started
+	s.Close()
+	assert.Error(t, <-cancelled)
+}
+
+func TestContextDeadlineAndValues(t *testing.T) {
+	type result struct {
+		err        error
+		id         string
+		remoteAddr net.Addr
+	}
+	results := make(chan result, 1)
+	s, err := server.Serve(0, func(w *response.Writer, req *request.Request) {
+		ctx := req.Context()
+		<-ctx.Done()
+		results <- result{
+			err:        ctx.Err(),
+			id:         request.IDFromContext(ctx),
+			remoteAddr: request.RemoteAddrFromContext(ctx),
+		}
+		okHandler(w, req)
+	}, server.WithHandlerTimeout(20*time.Millisecond))
+	require.NoError(t, err)
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+
+	r := <-results
+	assert.ErrorContains(t, r.err, "deadline exceeded")
+	assert.NotEmpty(t, r.id)
+	assert.Equal(t, conn.LocalAddr().String(), r.remoteAddr.String())
+}
+
+func TestHandlerPanicReturns500(t *testing.T) {
+	hookValues := make(chan any, 2)
+	s, err := server.Serve(0, func(w *response.Writer, req *request.Request) {
+		panic("boom")
+	}, server.WithPanicHook(func(req *request.Request, v any, stack []byte) {
+		hookValues <- v
+	}))
+	require.NoError(t, err)
+	defer s.Close()
+
+	resp, err := sendRawRequest(t, s.Addr().String(), "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 500 Internal Server Error\r\n", resp)
+
+	assert.Equal(t, "boom", <-hookValues)
+
+	// the server keeps accepting connections after a panic
+	_, err = sendRawRequest(t, s.Addr().String(), "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.NoError(t, err)
+}
 
-	// Expect error or empty response (because server logs and closes)
+func TestHandlerPanicDropsHandlerHeaders(t *testing.T) {
+	s, err := server.Serve(0, func(w *response.Writer, req *request.Request) {
+		w.Header.Set("Set-Cookie", "session=abc")
+		w.Header.Set("Content-Length", "999")
+		w.Header.Set("Content-Encoding", "gzip")
+		panic("boom")
+	})
+	require.NoError(t, err)
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\nX-Request-ID: abc-123\r\n\r\n")
+	require.NoError(t, err)
+	br := bufio.NewReader(conn)
+	line, err := br.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 500 Internal Server Error\r\n", line)
+	head := readHeaders(t, br)
+	assert.Contains(t, head, "x-request-id: abc-123\r\n")
+	assert.Contains(t, head, "content-length: 21\r\n")
+	assert.NotContains(t, head, "set-cookie")
+	assert.NotContains(t, head, "content-encoding")
+}
+
+func TestHandlerPanicAfterStatusAbortsConnection(t *testing.T) {
+	s, err := server.Serve(0, func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusOK)
+		w.Header.Set("Content-Length", "100")
+		w.WriteHeaders(w.Header)
+		w.WriteBody([]byte("partial"))
+		panic("boom")
+	})
+	require.NoError(t, err)
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+
+	r := bufio.NewReader(conn)
+	status, err := r.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", status)
+	_, err = io.ReadAll(r)
 	assert.Error(t, err)
 }
+
+func TestKeepAliveConnectionDetails(t *testing.T) {
+	reqs := make(chan *request.Request, 2)
+	s, err := server.Serve(0, func(w *response.Writer, req *request.Request) {
+		reqs <- req
+		okHandler(w, req)
+	})
+	require.NoError(t, err)
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	for i := 1; i <= 2; i++ {
+		_, err = fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+		require.NoError(t, err)
+		status, err := r.ReadString('\n')
+		require.NoError(t, err)
+		assert.Equal(t, "HTTP/1.1 200 OK\r\n", status)
+		for {
+			line, err := r.ReadString('\n')
+			require.NoError(t, err)
+			if line == "\r\n" {
+				break
+			}
+		}
+		body := make([]byte, 2)
+		_, err = io.ReadFull(r, body)
+		require.NoError(t, err)
+
+		req := <-reqs
+		assert.Equal(t, i, req.ConnRequests)
+		assert.Equal(t, conn.LocalAddr().String(), req.RemoteAddr.String())
+		assert.Equal(t, conn.RemoteAddr().String(), req.LocalAddr.String())
+		assert.NotZero(t, req.ConnID)
+		assert.False(t, req.StartTime.IsZero())
+		assert.Nil(t, req.TLS)
+	}
+}
+
+func TestMalformedTargetReturns400(t *testing.T) {
+	s, err := server.Serve(0, okHandler)
+	require.NoError(t, err)
+	defer s.Close()
+
+	resp, err := sendRawRequest(t, s.Addr().String(), "GET /a%zz HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 400 Bad Request\r\n", resp)
+}
+
+func TestEncodedSlashRejectedByDefault(t *testing.T) {
+	s, err := server.Serve(0, okHandler)
+	require.NoError(t, err)
+	defer s.Close()
+
+	resp, err := sendRawRequest(t, s.Addr().String(), "GET /a%2Fb HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 400 Bad Request\r\n", resp)
+
+	s2, err := server.Serve(0, okHandler, server.WithPathOptions(request.PathOptions{AllowEncodedSlash: true}))
+	require.NoError(t, err)
+	defer s2.Close()
+
+	resp, err = sendRawRequest(t, s2.Addr().String(), "GET /a%2Fb HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", resp)
+}
+
+func TestConnStateAndParseErrorHooks(t *testing.T) {
+	states := make(chan server.ConnState, 10)
+	parseErrs := make(chan error, 1)
+	s, err := server.Serve(0, okHandler,
+		server.WithConnStateHook(func(c net.Conn, state server.ConnState) { states <- state }),
+		server.WithParseErrorHook(func(err error) { parseErrs <- err }))
+	require.NoError(t, err)
+	defer s.Close()
+
+	_, err = sendRawRequest(t, s.Addr().String(), "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, server.StateNew, <-states)
+	assert.Equal(t, server.StateActive, <-states)
+
+	_, err = sendRawRequest(t, s.Addr().String(), "GET / HTTP/1.1\r\nBad Header\r\n\r\n")
+	require.NoError(t, err)
+	var perr *request.ParseError
+	require.ErrorAs(t, <-parseErrs, &perr)
+	assert.Equal(t, "header", perr.Kind)
+}
+
+func TestRequestIDGeneratedOrEchoed(t *testing.T) {
+	ids := make(chan string, 2)
+	s, err := server.Serve(0, func(w *response.Writer, req *request.Request) {
+		ids <- req.ID()
+		okHandler(w, req)
+	})
+	require.NoError(t, err)
+	defer s.Close()
+
+	readResponse := func(raw string) string {
+		conn, err := net.Dial("tcp", s.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+		_, err = fmt.Fprint(conn, raw)
+		require.NoError(t, err)
+		var b strings.Builder
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadString('\n')
+			require.NoError(t, err)
+			if line == "\r\n" {
+				return b.String()
+			}
+			b.WriteString(line)
+		}
+	}
+
+	resp := readResponse("GET / HTTP/1.1\r\nHost: localhost\r\nX-Request-ID: abc-123\r\n\r\n")
+	assert.Equal(t, "abc-123", <-ids)
+	assert.Contains(t, resp, "x-request-id: abc-123\r\n")
+
+	resp = readResponse("GET / HTTP/1.1\r\nHost: localhost\r\nX-Request-ID: bad id\r\n\r\n")
+	id := <-ids
+	assert.Len(t, id, 16)
+	assert.Contains(t, resp, "x-request-id: "+id+"\r\n")
+}
+
+func TestListenerWrapperRewritesRemoteAddr(t *testing.T) {
+	addrs := make(chan net.Addr, 1)
+	s, err := server.Serve(0, func(w *response.Writer, req *request.Request) {
+		addrs <- req.RemoteAddr
+		okHandler(w, req)
+	}, server.WithListenerWrapper(func(ln net.Listener) net.Listener {
+		return proxyproto.NewListener(ln, proxyproto.Config{
+			Trusted: []netip.Prefix{netip.MustParsePrefix("::1/128"), netip.MustParsePrefix("127.0.0.0/8")},
+		})
+	}))
+	require.NoError(t, err)
+	defer s.Close()
+
+	resp, err := sendRawRequest(t, s.Addr().String(),
+		"PROXY TCP4 203.0.113.7 10.0.0.1 51234 443\r\nGET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", resp)
+	assert.Equal(t, "203.0.113.7:51234", (<-addrs).String())
+}
+
+func TestTrustedProxiesResolveOrigin(t *testing.T) {
+	origins := make(chan request.Origin, 1)
+	s, err := server.Serve(0, func(w *response.Writer, req *request.Request) {
+		origins <- req.Origin
+		okHandler(w, req)
+	}, server.WithTrustedProxies(request.TrustedProxies{
+		Prefixes: []netip.Prefix{netip.MustParsePrefix("::1/128"), netip.MustParsePrefix("127.0.0.0/8")},
+	}))
+	require.NoError(t, err)
+	defer s.Close()
+
+	_, err = sendRawRequest(t, s.Addr().String(),
+		"GET / HTTP/1.1\r\nHost: localhost\r\nX-Forwarded-For: 198.51.100.20\r\nX-Forwarded-Proto: https\r\n\r\n")
+	require.NoError(t, err)
+	origin := <-origins
+	assert.Equal(t, "198.51.100.20", origin.IP.String())
+	assert.Equal(t, "https", origin.Scheme)
+	assert.Equal(t, "localhost", origin.Host)
+}
+
+func TestMissingOrDuplicateHostReturns400(t *testing.T) {
+	s, err := server.Serve(0, okHandler)
+	require.NoError(t, err)
+	defer s.Close()
+
+	resp, err := sendRawRequest(t, s.Addr().String(), "GET / HTTP/1.1\r\n\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 400 Bad Request\r\n", resp)
+
+	resp, err = sendRawRequest(t, s.Addr().String(), "GET / HTTP/1.1\r\nHost: a\r\nHost: b\r\n\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 400 Bad Request\r\n", resp)
+}
+
+func TestTransferEncodingConflictsCloseConnection(t *testing.T) {
+	s, err := server.Serve(0, okHandler)
+	require.NoError(t, err)
+	defer s.Close()
+
+	cases := []struct {
+		raw, status string
+	}{
+		// a smuggled second request must never be served
+		{"POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 4\r\nTransfer-Encoding: chunked\r\n\r\n" +
+			"0\r\n\r\nGET /smuggled HTTP/1.1\r\nHost: a\r\n\r\n", "HTTP/1.1 400 Bad Request\r\n"},
+		{"POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: gzip\r\n\r\n", "HTTP/1.1 501 Not Implemented\r\n"},
+	}
+	for _, c := range cases {
+		conn, err := net.Dial("tcp", s.Addr().String())
+		require.NoError(t, err)
+		_, err = io.WriteString(conn, c.raw)
+		require.NoError(t, err)
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		all, err := io.ReadAll(conn)
+		conn.Close()
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(string(all), c.status), string(all))
+		assert.Contains(t, string(all), "connection: close\r\n")
+		assert.Equal(t, 1, strings.Count(string(all), "HTTP/1.1 "), string(all))
+	}
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = io.WriteString(conn, "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n0\r\n\r\n")
+	require.NoError(t, err)
+	resp, err := bufio.NewReader(conn).ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", resp)
+}
+
+func TestStreamedBodyIsDrainedForNextRequest(t *testing.T) {
+	type result struct {
+		body   []byte
+		read   string
+		stream bool
+	}
+	results := make(chan result, 2)
+	s, err := server.Serve(0, func(w *response.Writer, req *request.Request) {
+		r := result{body: req.Body, stream: req.BodyReader != nil}
+		if r.stream {
+			// read only part of it
+			buf := make([]byte, 3)
+			n, _ := io.ReadFull(req.BodyReader, buf)
+			r.read = string(buf[:n])
+		}
+		results <- r
+		okHandler(w, req)
+	}, server.WithStreamedBodies(func(req *request.Request) bool {
+		return req.RequestLine.Path == "/upload"
+	}))
+	require.NoError(t, err)
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = io.WriteString(conn, "POST /upload HTTP/1.1\r\nHost: a\r\nContent-Length: 10\r\n\r\n0123456789"+
+		"POST /form HTTP/1.1\r\nHost: a\r\nContent-Length: 2\r\n\r\nhi")
+	require.NoError(t, err)
+
+	br := bufio.NewReader(conn)
+	for i := 0; i < 2; i++ {
+		line, err := br.ReadString('\n')
+		require.NoError(t, err)
+		assert.Equal(t, "HTTP/1.1 200 OK\r\n", line)
+		readHeaders(t, br)
+		_, err = io.ReadFull(br, make([]byte, 2))
+		require.NoError(t, err)
+	}
+
+	first := <-results
+	assert.True(t, first.stream)
+	assert.Empty(t, first.body)
+	assert.Equal(t, "012", first.read)
+	second := <-results
+	assert.False(t, second.stream)
+	assert.Equal(t, "hi", string(second.body))
+}
+
+func TestHeadResponseKeepsConnectionOpen(t *testing.T) {
+	s, err := server.Serve(0, okHandler)
+	require.NoError(t, err)
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+
+	// okHandler writes its body even for HEAD; it must not reach the wire
+	_, err = io.WriteString(conn, "HEAD / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	line, err := br.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", line)
+	assert.Contains(t, readHeaders(t, br), "content-length: 2\r\n")
+
+	_, err = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	line, err = br.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", line)
+}
+
+func TestPostWithoutLengthKeepsConnectionOpen(t *testing.T) {
+	s, err := server.Serve(0, okHandler)
+	require.NoError(t, err)
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	br := bufio.NewReader(conn)
+
+	// with neither Content-Length nor Transfer-Encoding the body is empty,
+	// so the server must not wait for the client to close
+	_, err = io.WriteString(conn, "POST / HTTP/1.1\r\nHost: x\r\n\r\n"+
+		"GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+	require.NoError(t, err)
+	for range 2 {
+		line, err := br.ReadString('\n')
+		require.NoError(t, err)
+		assert.Equal(t, "HTTP/1.1 200 OK\r\n", line)
+		readHeaders(t, br)
+		_, err = io.ReadFull(br, make([]byte, 2))
+		require.NoError(t, err)
+	}
+}
+
+func TestCloseClosesIdleConnections(t *testing.T) {
+	s, err := server.Serve(0, okHandler)
+	require.NoError(t, err)
+
+	idle, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer idle.Close()
+	_, err = io.WriteString(idle, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	br := bufio.NewReader(idle)
+	readHeaders(t, br)
+	_, err = io.ReadFull(br, make([]byte, 2))
+	require.NoError(t, err)
+
+	fresh, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer fresh.Close()
+	time.Sleep(50 * time.Millisecond)
+
+	require.NoError(t, s.Close())
+	for _, conn := range []net.Conn{idle, fresh} {
+		// well before the idle timeout
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, err = conn.Read(make([]byte, 1))
+		assert.Equal(t, io.EOF, err)
+	}
+}
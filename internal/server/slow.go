@@ -0,0 +1,150 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"os"
+	"time"
+
+	"github.com/sunilpar/My-Own-Http-Server/internal/response"
+)
+
+// MinDataRate is the slowest a client may send a request or read a
+// response. Grace is the time a transfer gets before the rate applies; a
+// zero BytesPerSecond disables the check.
+type MinDataRate struct {
+	BytesPerSecond int64
+	Grace          time.Duration
+}
+
+// DefaultMinDataRate applies to both directions unless overridden.
+var DefaultMinDataRate = MinDataRate{BytesPerSecond: 240, Grace: 5 * time.Second}
+
+// ErrSlowClient is returned by reads and writes that were cut off because
+// the client fell below the minimum data rate.
+var ErrSlowClient = errors.New("client below minimum data rate")
+
+// WithMinReadRate sets how fast clients must send their requests, counted
+// from the first byte of a request.
+func WithMinReadRate(r MinDataRate) Option {
+	return func(s *Server) {
+		s.minReadRate = r
+	}
+}
+
+// WithMinWriteRate sets how fast clients must read responses. Only the time
+// the server spends blocked on writes counts, so a handler that is slow to
+// produce data, like an event stream, is not penalised.
+func WithMinWriteRate(r MinDataRate) Option {
+	return func(s *Server) {
+		s.minWriteRate = r
+	}
+}
+
+// WithSlowClientHook calls hook before a connection is closed for falling
+// below the minimum data rate. direction is "read" for a slow request and
+// "write" for a slow response.
+func WithSlowClientHook(hook func(conn net.Conn, direction string)) Option {
+	return func(s *Server) {
+		s.slowClientHook = hook
+	}
+}
+
+func (r MinDataRate) enabled() bool {
+	return r.BytesPerSecond > 0
+}
+
+// allowance is the time a transfer of n bytes may take.
+func (r MinDataRate) allowance(n int64) time.Duration {
+	return r.Grace + time.Duration(float64(n)/float64(r.BytesPerSecond)*float64(time.Second))
+}
+
+func (s *Server) reportSlowClient(conn net.Conn, direction string) {
+	log.Printf("Closing connection from %s: %s below minimum data rate\n", conn.RemoteAddr(), direction)
+	if s.slowClientHook != nil {
+		s.slowClientHook(conn, direction)
+	}
+}
+
+// rateConn enforces a minimum write rate on one response by setting a write
+// deadline before every Write.
+type rateConn struct {
+	net.Conn
+	rate    MinDataRate
+	bytes   int64
+	busy    time.Duration
+	tooSlow bool
+}
+
+func newRateConn(conn net.Conn, rate MinDataRate) *rateConn {
+	return &rateConn{Conn: conn, rate: rate}
+}
+
+func (c *rateConn) Write(p []byte) (int, error) {
+	if !c.rate.enabled() {
+		return c.Conn.Write(p)
+	}
+	start := time.Now()
+	c.bytes += int64(len(p))
+	c.Conn.SetWriteDeadline(start.Add(c.rate.allowance(c.bytes) - c.busy))
+	n, err := c.Conn.Write(p)
+	c.busy += time.Since(start)
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		c.tooSlow = true
+		err = fmt.Errorf("%w: %w", ErrSlowClient, err)
+	}
+	return n, err
+}
+
+// rateSlice is how much of a ReadFrom is sent under one write deadline.
+const rateSlice = 256 << 10
+
+// ReadFrom passes the copy on to the connection so files can still go out
+// with sendfile. With a minimum rate the copy is made in slices, each with
+// its own deadline.
+func (c *rateConn) ReadFrom(r io.Reader) (int64, error) {
+	rf, ok := c.Conn.(io.ReaderFrom)
+	if !ok {
+		return io.Copy(response.WriterOnly{Writer: c}, r)
+	}
+	if !c.rate.enabled() {
+		return rf.ReadFrom(r)
+	}
+
+	// sendfile only looks through one LimitedReader, so an already limited
+	// file is sliced with fresh ones
+	remain := int64(math.MaxInt64)
+	if lr, ok := r.(*io.LimitedReader); ok {
+		r, remain = lr.R, lr.N
+		defer func() { lr.N = remain }()
+	}
+	var total int64
+	for remain > 0 {
+		size := min(remain, rateSlice)
+		start := time.Now()
+		c.Conn.SetWriteDeadline(start.Add(c.rate.allowance(c.bytes+size) - c.busy))
+		n, err := rf.ReadFrom(&io.LimitedReader{R: r, N: size})
+		c.bytes += n
+		c.busy += time.Since(start)
+		total += n
+		remain -= n
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			c.tooSlow = true
+			return total, fmt.Errorf("%w: %w", ErrSlowClient, err)
+		}
+		if err != nil || n < size {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+func (c *rateConn) done() {
+	if c.rate.enabled() {
+		c.Conn.SetWriteDeadline(time.Time{})
+	}
+}
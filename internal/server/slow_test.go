@@ -0,0 +1,215 @@
+package server_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunilpar/My-Own-Http-Server/internal/request"
+	"github.com/sunilpar/My-Own-Http-Server/internal/response"
+	"github.com/sunilpar/My-Own-Http-Server/internal/server"
+)
+
+func TestSlowRequestIsClosed(t *testing.T) {
+	slow := make(chan string, 1)
+	s, err := server.Serve(0, okHandler,
+		server.WithMinReadRate(server.MinDataRate{BytesPerSecond: 1000, Grace: 100 * time.Millisecond}),
+		server.WithSlowClientHook(func(c net.Conn, direction string) { slow <- direction }))
+	require.NoError(t, err)
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// one byte every 50ms is far below 1000 bytes per second
+	raw := "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"
+	closed := make(chan error, 1)
+	go func() {
+		for i := 0; i < len(raw); i++ {
+			if _, err := conn.Write([]byte{raw[i]}); err != nil {
+				closed <- err
+				return
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		closed <- nil
+	}()
+
+	select {
+	case direction := <-slow:
+		assert.Equal(t, "read", direction)
+	case <-time.After(2 * time.Second):
+		t.Fatal("slow request was not cut off")
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, _ := conn.Read(make([]byte, 1))
+	assert.Zero(t, n)
+}
+
+func TestIdleConnectionWithoutRequestIsClosed(t *testing.T) {
+	slow := make(chan string, 1)
+	s, err := server.Serve(0, okHandler,
+		server.WithMinReadRate(server.MinDataRate{BytesPerSecond: 1000, Grace: 50 * time.Millisecond}),
+		server.WithIdleTimeout(300*time.Millisecond),
+		server.WithSlowClientHook(func(c net.Conn, direction string) { slow <- direction }))
+	require.NoError(t, err)
+	defer s.Close()
+
+	// a connection opened ahead of time is served well past the grace
+	// period, once its request starts
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	time.Sleep(150 * time.Millisecond)
+	resp, err := func() (string, error) {
+		fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		b := make([]byte, 17)
+		_, err := io.ReadFull(conn, b)
+		return string(b), err
+	}()
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", resp)
+
+	// one that never sends a request is closed at the idle timeout, without
+	// being reported as slow
+	idle, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer idle.Close()
+	idle.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, err := idle.Read(make([]byte, 1))
+	assert.Zero(t, n)
+	assert.Equal(t, io.EOF, err)
+	assert.Empty(t, slow)
+}
+
+func TestKeptAliveConnectionIsNotRateLimitedWhileIdle(t *testing.T) {
+	slow := make(chan string, 1)
+	s, err := server.Serve(0, okHandler,
+		server.WithMinReadRate(server.MinDataRate{BytesPerSecond: 1000, Grace: 50 * time.Millisecond}),
+		server.WithSlowClientHook(func(c net.Conn, direction string) { slow <- direction }))
+	require.NoError(t, err)
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	raw := "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"
+	for i := 0; i < 2; i++ {
+		_, err = fmt.Fprint(conn, raw)
+		require.NoError(t, err)
+		resp := make([]byte, 256)
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, err := conn.Read(resp)
+		require.NoError(t, err)
+		assert.True(t, bytes.HasPrefix(resp[:n], []byte("HTTP/1.1 200 OK")))
+		time.Sleep(200 * time.Millisecond)
+	}
+	assert.Empty(t, slow)
+}
+
+func TestSlowReaderIsClosed(t *testing.T) {
+	slow := make(chan string, 1)
+	writeErr := make(chan error, 1)
+	body := bytes.Repeat([]byte("x"), 16<<20)
+	s, err := server.Serve(0, func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusOK)
+		w.Header.Set("Content-Length", fmt.Sprintf("%d", len(body)))
+		w.WriteHeaders(w.Header)
+		_, err := w.WriteBody(body)
+		writeErr <- err
+	},
+		server.WithMinWriteRate(server.MinDataRate{BytesPerSecond: 64 << 20, Grace: 100 * time.Millisecond}),
+		server.WithSlowClientHook(func(c net.Conn, direction string) { slow <- direction }))
+	require.NoError(t, err)
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+
+	// never read the response
+	select {
+	case direction := <-slow:
+		assert.Equal(t, "write", direction)
+	case <-time.After(3 * time.Second):
+		t.Fatal("slow reader was not cut off")
+	}
+	assert.ErrorIs(t, <-writeErr, server.ErrSlowClient)
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _ := io.Copy(io.Discard, conn)
+	assert.Less(t, n, int64(len(body)))
+}
+
+func serveFile(t *testing.T, size int, opts ...server.Option) (*server.Server, chan error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "data")
+	require.NoError(t, os.WriteFile(path, bytes.Repeat([]byte("x"), size), 0o644))
+	writeErr := make(chan error, 1)
+	s, err := server.Serve(0, func(w *response.Writer, req *request.Request) {
+		f, err := os.Open(path)
+		if err != nil {
+			writeErr <- err
+			return
+		}
+		defer f.Close()
+		w.WriteStatusLine(response.StatusOK)
+		w.Header.Set("Content-Length", fmt.Sprintf("%d", size))
+		w.WriteHeaders(w.Header)
+		_, err = w.ReadFrom(io.LimitReader(f, int64(size)))
+		writeErr <- err
+	}, opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return s, writeErr
+}
+
+func TestFileBodyUnderWriteRate(t *testing.T) {
+	s, writeErr := serveFile(t, 4<<20)
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	require.NoError(t, err)
+
+	resp, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	require.NoError(t, <-writeErr)
+	_, body, _ := bytes.Cut(resp, []byte("\r\n\r\n"))
+	assert.Len(t, body, 4<<20)
+}
+
+func TestSlowReaderOfFileIsClosed(t *testing.T) {
+	slow := make(chan string, 1)
+	s, writeErr := serveFile(t, 16<<20,
+		server.WithMinWriteRate(server.MinDataRate{BytesPerSecond: 64 << 20, Grace: 100 * time.Millisecond}),
+		server.WithSlowClientHook(func(c net.Conn, direction string) { slow <- direction }))
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+
+	select {
+	case direction := <-slow:
+		assert.Equal(t, "write", direction)
+	case <-time.After(3 * time.Second):
+		t.Fatal("slow reader was not cut off")
+	}
+	assert.ErrorIs(t, <-writeErr, server.ErrSlowClient)
+}
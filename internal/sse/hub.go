@@ -0,0 +1,145 @@
+package sse
+
+import (
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sunilpar/My-Own-Http-Server/internal/request"
+	"github.com/sunilpar/My-Own-Http-Server/internal/response"
+)
+
+const (
+	defaultHistory   = 256
+	subscriberBuffer = 64
+)
+
+type Hub struct {
+	mu          sync.Mutex
+	nextID      uint64
+	history     []Event
+	maxHistory  int
+	subscribers map[*Subscription]struct{}
+
+	HeartbeatInterval time.Duration
+}
+
+type Subscription struct {
+	C   <-chan Event
+	ch  chan Event
+	hub *Hub
+}
+
+// NewHub returns a hub that keeps the last historySize events around for
+// clients reconnecting with Last-Event-ID.
+func NewHub(historySize int) *Hub {
+	if historySize <= 0 {
+		historySize = defaultHistory
+	}
+	return &Hub{
+		maxHistory:        historySize,
+		subscribers:       make(map[*Subscription]struct{}),
+		HeartbeatInterval: 15 * time.Second,
+	}
+}
+
+// Publish assigns an ID to the event if it has none, records it in the
+// history and fans it out to every subscriber. Subscribers that cannot keep
+// up are dropped; they are expected to reconnect and replay.
+func (h *Hub) Publish(e Event) Event {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.nextID++
+	if e.ID == "" {
+		e.ID = strconv.FormatUint(h.nextID, 10)
+	}
+	h.history = append(h.history, e)
+	if len(h.history) > h.maxHistory {
+		h.history = h.history[len(h.history)-h.maxHistory:]
+	}
+
+	for sub := range h.subscribers {
+		select {
+		case sub.ch <- e:
+		default:
+			delete(h.subscribers, sub)
+			close(sub.ch)
+		}
+	}
+	return e
+}
+
+// Subscribe registers a new subscriber. When lastEventID names an event
+// still in the history, every event published after it is queued first.
+func (h *Hub) Subscribe(lastEventID string) *Subscription {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var missed []Event
+	if lastEventID != "" {
+		for i, e := range h.history {
+			if e.ID == lastEventID {
+				missed = h.history[i+1:]
+				break
+			}
+		}
+	}
+
+	ch := make(chan Event, len(missed)+subscriberBuffer)
+	for _, e := range missed {
+		ch <- e
+	}
+	sub := &Subscription{C: ch, ch: ch, hub: h}
+	h.subscribers[sub] = struct{}{}
+	return sub
+}
+
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	if _, ok := s.hub.subscribers[s]; ok {
+		delete(s.hub.subscribers, s)
+		close(s.ch)
+	}
+}
+
+func (h *Hub) Subscribers() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subscribers)
+}
+
+func (h *Hub) Handler(w *response.Writer, req *request.Request) {
+	stream, err := NewStream(w)
+	if err != nil {
+		log.Printf("Error starting event stream: %v", err)
+		return
+	}
+	// closing the stream also stops the heartbeat, which must not write
+	// once the handler has returned
+	defer stream.Close()
+	sub := h.Subscribe(req.Headers.Get("Last-Event-ID"))
+	defer sub.Close()
+
+	if h.HeartbeatInterval > 0 {
+		stream.Heartbeat(h.HeartbeatInterval)
+	}
+
+	for {
+		select {
+		case <-stream.Done():
+			return
+		case <-req.Context().Done():
+			return
+		case e, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			if err := stream.Send(e); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package sse
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sunilpar/My-Own-Http-Server/internal/headers"
+	"github.com/sunilpar/My-Own-Http-Server/internal/response"
+)
+
+var ErrStreamClosed = errors.New("sse stream closed")
+
+type Event struct {
+	ID    string
+	Event string
+	Data  string
+	Retry time.Duration
+}
+
+type Stream struct {
+	w      *response.Writer
+	mu     sync.Mutex
+	done   chan struct{}
+	closed bool
+	err    error
+}
+
+// NewStream writes the status line and event-stream headers, after which
+// events are sent as chunks on the same connection.
+func NewStream(w *response.Writer) (*Stream, error) {
+	if err := w.WriteStatusLine(response.StatusOK); err != nil {
+		return nil, err
+	}
+	w.Header.Set("Content-Type", "text/event-stream")
+	w.Header.Set("Cache-Control", "no-cache")
+	w.Header.Set("Connection", "keep-alive")
+	w.Header.Del("Content-Length")
+	w.Header.Set("Transfer-Encoding", "chunked")
+	if err := w.WriteHeaders(w.Header); err != nil {
+		return nil, err
+	}
+	return &Stream{
+		w:    w,
+		done: make(chan struct{}),
+	}, nil
+}
+
+func (e Event) encode() ([]byte, error) {
+	if strings.ContainsAny(e.ID, "\r\n\x00") {
+		return nil, fmt.Errorf("invalid character in event id")
+	}
+	if strings.ContainsAny(e.Event, "\r\n") {
+		return nil, fmt.Errorf("invalid character in event name")
+	}
+
+	var b strings.Builder
+	if e.ID != "" {
+		fmt.Fprintf(&b, "id: %s\n", e.ID)
+	}
+	if e.Event != "" {
+		fmt.Fprintf(&b, "event: %s\n", e.Event)
+	}
+	if e.Retry > 0 {
+		fmt.Fprintf(&b, "retry: %d\n", e.Retry.Milliseconds())
+	}
+	data := strings.ReplaceAll(e.Data, "\r\n", "\n")
+	data = strings.ReplaceAll(data, "\r", "\n")
+	for _, line := range strings.Split(data, "\n") {
+		fmt.Fprintf(&b, "data: %s\n", line)
+	}
+	b.WriteString("\n")
+	return []byte(b.String()), nil
+}
+
+func (s *Stream) Send(e Event) error {
+	p, err := e.encode()
+	if err != nil {
+		return err
+	}
+	return s.write(p)
+}
+
+func (s *Stream) Comment(text string) error {
+	var b strings.Builder
+	for _, line := range strings.Split(text, "\n") {
+		fmt.Fprintf(&b, ": %s\n", line)
+	}
+	b.WriteString("\n")
+	return s.write([]byte(b.String()))
+}
+
+func (s *Stream) write(p []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		if s.err != nil {
+			return s.err
+		}
+		return ErrStreamClosed
+	}
+	if _, err := s.w.WriteChunkedBody(p); err != nil {
+		// a failed write means the client has gone away
+		s.err = err
+		s.closed = true
+		close(s.done)
+		return err
+	}
+	return nil
+}
+
+// Heartbeat sends a comment every interval so that idle streams are kept
+// open by intermediaries and a disconnected client is noticed on write.
+func (s *Stream) Heartbeat(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.done:
+				return
+			case <-ticker.C:
+				if err := s.Comment("ping"); err != nil {
+					return
+				}
+			}
+		}
+	}()
+}
+
+// Done is closed once the stream is closed or the client disconnects.
+func (s *Stream) Done() <-chan struct{} {
+	return s.done
+}
+
+func (s *Stream) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+func (s *Stream) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return s.err
+	}
+	s.closed = true
+	close(s.done)
+	if _, err := s.w.WriteChunkedBodyDone(); err != nil {
+		return err
+	}
+	return s.w.WriteTrailers(headers.NewHeaders())
+}
@@ -0,0 +1,177 @@
+package sse
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunilpar/My-Own-Http-Server/internal/headers"
+	"github.com/sunilpar/My-Own-Http-Server/internal/request"
+	"github.com/sunilpar/My-Own-Http-Server/internal/response"
+)
+
+func TestEventEncoding(t *testing.T) {
+	p, err := Event{
+		ID:    "7",
+		Event: "update",
+		Data:  "line one\nline two",
+		Retry: 3 * time.Second,
+	}.encode()
+	require.NoError(t, err)
+	assert.Equal(t, "id: 7\nevent: update\nretry: 3000\ndata: line one\ndata: line two\n\n", string(p))
+}
+
+func TestEventEncodingRejectsNewlineInID(t *testing.T) {
+	_, err := Event{ID: "1\n2", Data: "x"}.encode()
+	require.Error(t, err)
+}
+
+func TestStreamWritesChunkedEvents(t *testing.T) {
+	server, client := net.Pipe()
+	out := make(chan string)
+	go func() {
+		b, _ := io.ReadAll(client)
+		out <- string(b)
+	}()
+
+	stream, err := NewStream(response.NewWriter(server))
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(Event{Data: "hi"}))
+	require.NoError(t, stream.Close())
+	server.Close()
+
+	resp := <-out
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, resp, "content-type: text/event-stream\r\n")
+	assert.Contains(t, resp, "\r\n\r\na\r\ndata: hi\n\n\r\n0\r\n\r\n")
+}
+
+func TestStreamDoneOnDisconnect(t *testing.T) {
+	server, client := net.Pipe()
+	go io.Copy(io.Discard, client)
+
+	stream, err := NewStream(response.NewWriter(server))
+	require.NoError(t, err)
+	client.Close()
+
+	require.Error(t, stream.Send(Event{Data: "lost"}))
+	select {
+	case <-stream.Done():
+	case <-time.After(time.Second):
+		t.Fatal("stream not marked done after disconnect")
+	}
+}
+
+func TestHubReplaysFromLastEventID(t *testing.T) {
+	hub := NewHub(10)
+	for _, d := range []string{"a", "b", "c"} {
+		hub.Publish(Event{Data: d})
+	}
+
+	sub := hub.Subscribe("1")
+	defer sub.Close()
+	assert.Equal(t, "b", (<-sub.C).Data)
+	assert.Equal(t, "c", (<-sub.C).Data)
+
+	hub.Publish(Event{Data: "d"})
+	e := <-sub.C
+	assert.Equal(t, "4", e.ID)
+	assert.Equal(t, "d", e.Data)
+}
+
+func TestHubHistoryIsBounded(t *testing.T) {
+	hub := NewHub(2)
+	for _, d := range []string{"a", "b", "c"} {
+		hub.Publish(Event{Data: d})
+	}
+	sub := hub.Subscribe("1")
+	defer sub.Close()
+	assert.Len(t, sub.C, 0)
+	assert.Len(t, hub.history, 2)
+}
+
+func TestHubHandlerStreamsToClient(t *testing.T) {
+	hub := NewHub(10)
+	hub.HeartbeatInterval = 0
+	hub.Publish(Event{Data: "old"})
+
+	server, client := net.Pipe()
+	req := &request.Request{Headers: headers.NewHeaders()}
+	req.Headers.Set("Last-Event-ID", "1")
+	handlerDone := make(chan struct{})
+	go func() {
+		hub.Handler(response.NewWriter(server), req)
+		close(handlerDone)
+	}()
+
+	r := bufio.NewReader(client)
+	for {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		if line == "\r\n" {
+			break
+		}
+	}
+
+	for hub.Subscribers() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	hub.Publish(Event{Data: "new"})
+
+	size, err := r.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "11\r\n", size)
+	line, err := r.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "id: 2\n", line)
+	line, err = r.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "data: new\n", line)
+
+	client.Close()
+	hub.Publish(Event{Data: "after close"})
+	select {
+	case <-handlerDone:
+	case <-time.After(time.Second):
+		t.Fatal("handler did not return after disconnect")
+	}
+}
+
+func TestHubHandlerStopsHeartbeatOnCancel(t *testing.T) {
+	hub := NewHub(10)
+	hub.HeartbeatInterval = time.Millisecond
+
+	server, client := net.Pipe()
+	defer client.Close()
+	go io.Copy(io.Discard, client)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req := (&request.Request{Headers: headers.NewHeaders()}).WithContext(ctx)
+	w := response.NewWriter(server)
+	handlerDone := make(chan struct{})
+	go func() {
+		hub.Handler(w, req)
+		close(handlerDone)
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	select {
+	case <-handlerDone:
+	case <-time.After(time.Second):
+		t.Fatal("handler did not return after cancel")
+	}
+
+	// the server keeps using the writer once the handler returns
+	written := w.BytesWritten()
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, written, w.BytesWritten())
+	w.Finish()
+}
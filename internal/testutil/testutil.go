@@ -0,0 +1,50 @@
+// Package testutil runs handlers against a single request for tests and
+// picks apart what they wrote.
+package testutil
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunilpar/My-Own-Http-Server/internal/request"
+	"github.com/sunilpar/My-Own-Http-Server/internal/response"
+)
+
+// Serve parses raw as a request, runs h on it and returns everything h
+// wrote to the connection.
+func Serve(t testing.TB, h func(*response.Writer, *request.Request), raw string) string {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	return ServeRequest(t, h, req)
+}
+
+// ServeRequest is like Serve for a request built by the caller.
+func ServeRequest(t testing.TB, h func(*response.Writer, *request.Request), req *request.Request) string {
+	t.Helper()
+	conn, client := net.Pipe()
+	out := make(chan string)
+	go func() {
+		b, _ := io.ReadAll(client)
+		out <- string(b)
+	}()
+	h(response.NewWriter(conn), req)
+	conn.Close()
+	return <-out
+}
+
+// Status returns the status line of a raw response.
+func Status(resp string) string {
+	line, _, _ := strings.Cut(resp, "\r\n")
+	return line
+}
+
+// Body returns what follows the headers of a raw response.
+func Body(resp string) string {
+	_, b, _ := strings.Cut(resp, "\r\n\r\n")
+	return b
+}
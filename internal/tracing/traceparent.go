@@ -0,0 +1,103 @@
+package tracing
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+type TraceID [16]byte
+type SpanID [8]byte
+
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+func (s SpanID) String() string  { return hex.EncodeToString(s[:]) }
+
+func (t TraceID) IsValid() bool { return t != TraceID{} }
+func (s SpanID) IsValid() bool  { return s != SpanID{} }
+
+const FlagSampled byte = 0x01
+
+// SpanContext is the part of a span that crosses process boundaries in the
+// W3C traceparent and tracestate headers.
+type SpanContext struct {
+	TraceID    TraceID
+	SpanID     SpanID
+	Flags      byte
+	TraceState string
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+func (sc SpanContext) Sampled() bool {
+	return sc.Flags&FlagSampled != 0
+}
+
+func (sc SpanContext) Traceparent() string {
+	return fmt.Sprintf("00-%s-%s-%02x", sc.TraceID, sc.SpanID, sc.Flags)
+}
+
+// ParseTraceparent parses a traceparent header as defined by W3C Trace
+// Context level 1. Versions above 00 are accepted as long as they start with
+// the version 00 fields.
+func ParseTraceparent(s string) (SpanContext, error) {
+	var sc SpanContext
+	s = strings.TrimSpace(s)
+	if len(s) < 55 {
+		return sc, fmt.Errorf("traceparent too short")
+	}
+	version, err := hex.DecodeString(s[0:2])
+	if err != nil || s[0:2] != strings.ToLower(s[0:2]) || version[0] == 0xff {
+		return sc, fmt.Errorf("invalid traceparent version %q", s[0:2])
+	}
+	if version[0] == 0 && len(s) != 55 {
+		return sc, fmt.Errorf("traceparent version 00 must be 55 characters")
+	}
+	if version[0] > 0 && len(s) > 55 && s[55] != '-' {
+		return sc, fmt.Errorf("malformed traceparent")
+	}
+	if s[2] != '-' || s[35] != '-' || s[52] != '-' {
+		return sc, fmt.Errorf("malformed traceparent")
+	}
+	if err := decodeLowerHex(sc.TraceID[:], s[3:35]); err != nil {
+		return sc, fmt.Errorf("invalid trace-id: %w", err)
+	}
+	if err := decodeLowerHex(sc.SpanID[:], s[36:52]); err != nil {
+		return sc, fmt.Errorf("invalid parent-id: %w", err)
+	}
+	var flags [1]byte
+	if err := decodeLowerHex(flags[:], s[53:55]); err != nil {
+		return sc, fmt.Errorf("invalid trace-flags: %w", err)
+	}
+	sc.Flags = flags[0]
+	if !sc.IsValid() {
+		return sc, fmt.Errorf("all-zero trace-id or parent-id")
+	}
+	return sc, nil
+}
+
+func decodeLowerHex(dst []byte, s string) error {
+	if s != strings.ToLower(s) {
+		return fmt.Errorf("uppercase hex in %q", s)
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err
+}
+
+func newTraceID() TraceID {
+	var t TraceID
+	for !t.IsValid() {
+		rand.Read(t[:])
+	}
+	return t
+}
+
+func newSpanID() SpanID {
+	var s SpanID
+	for !s.IsValid() {
+		rand.Read(s[:])
+	}
+	return s
+}
@@ -0,0 +1,196 @@
+package tracing
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sunilpar/My-Own-Http-Server/internal/request"
+	"github.com/sunilpar/My-Own-Http-Server/internal/response"
+	"github.com/sunilpar/My-Own-Http-Server/internal/server"
+)
+
+// SpanKind values match the OTLP enumeration.
+type SpanKind int
+
+const (
+	KindInternal SpanKind = 1
+	KindServer   SpanKind = 2
+	KindClient   SpanKind = 3
+)
+
+type StatusCode int
+
+const (
+	StatusUnset StatusCode = iota
+	StatusOK
+	StatusError
+)
+
+type Span struct {
+	Name       string
+	Kind       SpanKind
+	Context    SpanContext
+	Parent     SpanID
+	Start      time.Time
+	EndTime    time.Time
+	Attributes map[string]any
+	Status     StatusCode
+	StatusMsg  string
+
+	tracer *Tracer
+	mu     sync.Mutex
+	ended  bool
+}
+
+// SetAttribute records a string, bool, int or int64 attribute.
+func (s *Span) SetAttribute(key string, value any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Attributes[key] = value
+}
+
+func (s *Span) SetStatus(code StatusCode, msg string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Status = code
+	s.StatusMsg = msg
+}
+
+// End finishes the span and, if it is sampled, hands it to the exporter.
+// Calls after the first are ignored.
+func (s *Span) End() {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.EndTime = time.Now()
+	s.mu.Unlock()
+
+	if s.Context.Sampled() && s.tracer.exporter != nil {
+		s.tracer.exporter.Export(s)
+	}
+}
+
+type Exporter interface {
+	Export(*Span) error
+}
+
+type Tracer struct {
+	exporter Exporter
+}
+
+func NewTracer(exp Exporter) *Tracer {
+	return &Tracer{exporter: exp}
+}
+
+type contextKey int
+
+const (
+	spanKey contextKey = iota
+	remoteKey
+)
+
+func ContextWithSpan(ctx context.Context, s *Span) context.Context {
+	return context.WithValue(ctx, spanKey, s)
+}
+
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey).(*Span)
+	return s
+}
+
+// ContextWithRemoteParent records a span context received from another
+// process, to be used as the parent of the next span started from ctx.
+func ContextWithRemoteParent(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteKey, sc)
+}
+
+// SpanContextFromContext returns the context of the active span, falling
+// back to a remote parent.
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	if s := SpanFromContext(ctx); s != nil {
+		return s.Context
+	}
+	sc, _ := ctx.Value(remoteKey).(SpanContext)
+	return sc
+}
+
+// Start begins a span that is a child of whatever span ctx carries, or the
+// root of a new sampled trace when there is none.
+func (t *Tracer) Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	parent := SpanContextFromContext(ctx)
+	s := &Span{
+		Name:       name,
+		Kind:       kind,
+		Start:      time.Now(),
+		Attributes: make(map[string]any),
+		tracer:     t,
+	}
+	if parent.IsValid() {
+		s.Context = SpanContext{
+			TraceID:    parent.TraceID,
+			Flags:      parent.Flags,
+			TraceState: parent.TraceState,
+		}
+		s.Parent = parent.SpanID
+	} else {
+		s.Context = SpanContext{TraceID: newTraceID(), Flags: FlagSampled}
+	}
+	s.Context.SpanID = newSpanID()
+	return ContextWithSpan(ctx, s), s
+}
+
+// Inject writes the traceparent and tracestate headers for the span carried
+// by ctx using set, e.g. an http.Header's Set method.
+func Inject(ctx context.Context, set func(key, value string)) {
+	sc := SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return
+	}
+	set("traceparent", sc.Traceparent())
+	if sc.TraceState != "" {
+		set("tracestate", sc.TraceState)
+	}
+}
+
+// Middleware continues the trace from the request's traceparent header, if
+// it is valid, and wraps the handler in a server span.
+func (t *Tracer) Middleware(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		ctx := req.Context()
+		if sc, err := ParseTraceparent(req.Headers.Get("traceparent")); err == nil {
+			sc.TraceState = req.Headers.Get("tracestate")
+			ctx = ContextWithRemoteParent(ctx, sc)
+		}
+		ctx, span := t.Start(ctx, req.RequestLine.Method, KindServer)
+		if !req.StartTime.IsZero() {
+			span.Start = req.StartTime
+		}
+		defer span.End()
+
+		req = req.WithContext(ctx)
+		next(w, req)
+
+		if req.Pattern != "" {
+			span.Name = req.RequestLine.Method + " " + req.Pattern
+			span.SetAttribute("http.route", req.Pattern)
+		}
+		span.SetAttribute("http.request.method", req.RequestLine.Method)
+		span.SetAttribute("url.path", req.RequestLine.Path)
+		span.SetAttribute("http.response.status_code", int(w.Status()))
+		span.SetAttribute("http.request.id", req.ID())
+		if req.Origin.IP.IsValid() {
+			span.SetAttribute("client.address", req.Origin.IP.String())
+		}
+		if req.RemoteAddr != nil {
+			span.SetAttribute("network.peer.address", req.RemoteAddr.String())
+		}
+		if w.Status() >= 500 {
+			span.SetStatus(StatusError, strconv.Itoa(int(w.Status())))
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunilpar/My-Own-Http-Server/internal/request"
+	"github.com/sunilpar/My-Own-Http-Server/internal/response"
+)
+
+const parent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func TestParseTraceparent(t *testing.T) {
+	sc, err := ParseTraceparent(parent)
+	require.NoError(t, err)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID.String())
+	assert.Equal(t, "00f067aa0ba902b7", sc.SpanID.String())
+	assert.True(t, sc.Sampled())
+	assert.Equal(t, parent, sc.Traceparent())
+
+	_, err = ParseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-future")
+	assert.NoError(t, err)
+}
+
+func TestParseTraceparentRejectsInvalid(t *testing.T) {
+	for _, s := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00_4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-zz",
+	} {
+		_, err := ParseTraceparent(s)
+		assert.Error(t, err, s)
+	}
+}
+
+type recorder struct{ spans []*Span }
+
+func (r *recorder) Export(s *Span) error {
+	r.spans = append(r.spans, s)
+	return nil
+}
+
+func TestMiddlewareContinuesTraceAndInjects(t *testing.T) {
+	rec := &recorder{}
+	tracer := NewTracer(rec)
+
+	req, err := request.RequestFromReader(strings.NewReader("GET /x HTTP/1.1\r\nHost: a\r\n" +
+		"traceparent: " + parent + "\r\ntracestate: vendor=1\r\n\r\n"))
+	require.NoError(t, err)
+
+	conn, client := net.Pipe()
+	go io.Copy(io.Discard, client)
+	defer conn.Close()
+
+	injected := map[string]string{}
+	tracer.Middleware(func(w *response.Writer, req *request.Request) {
+		ctx, child := tracer.Start(req.Context(), "upstream", KindClient)
+		Inject(ctx, func(k, v string) { injected[k] = v })
+		child.End()
+
+		w.WriteStatusLine(response.StatusInternalServerError)
+		w.Header.Set("Content-Length", "0")
+		w.WriteHeaders(w.Header)
+	})(response.NewWriter(conn), req)
+
+	require.Len(t, rec.spans, 2)
+	child, srv := rec.spans[0], rec.spans[1]
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", srv.Context.TraceID.String())
+	assert.Equal(t, "00f067aa0ba902b7", srv.Parent.String())
+	assert.Equal(t, srv.Context.SpanID, child.Parent)
+	assert.Equal(t, KindServer, srv.Kind)
+	assert.Equal(t, StatusError, srv.Status)
+	assert.Equal(t, 500, srv.Attributes["http.response.status_code"])
+
+	assert.Equal(t, child.Context.Traceparent(), injected["traceparent"])
+	assert.Equal(t, "vendor=1", injected["tracestate"])
+}
+
+func TestUnsampledTraceIsPropagatedButNotExported(t *testing.T) {
+	rec := &recorder{}
+	tracer := NewTracer(rec)
+	sc, err := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	require.NoError(t, err)
+
+	ctx, span := tracer.Start(ContextWithRemoteParent(context.Background(), sc), "op", KindInternal)
+	span.End()
+	assert.Empty(t, rec.spans)
+	assert.Equal(t, sc.TraceID, SpanContextFromContext(ctx).TraceID)
+}
+
+func TestFileExporterWritesOTLPJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spans.json")
+	exp, err := NewFileExporter(path, "httpserver")
+	require.NoError(t, err)
+	tracer := NewTracer(exp)
+
+	_, span := tracer.Start(context.Background(), "GET /", KindServer)
+	span.SetAttribute("http.response.status_code", 200)
+	span.SetAttribute("url.path", "/")
+	span.End()
+	require.NoError(t, exp.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var got otlpRequest
+	require.NoError(t, json.Unmarshal(data, &got))
+
+	require.Len(t, got.ResourceSpans, 1)
+	assert.Equal(t, "httpserver", *got.ResourceSpans[0].Resource.Attributes[0].Value.StringValue)
+	s := got.ResourceSpans[0].ScopeSpans[0].Spans[0]
+	assert.Equal(t, span.Context.TraceID.String(), s.TraceID)
+	assert.Empty(t, s.ParentSpanID)
+	assert.Equal(t, KindServer, s.Kind)
+	assert.Equal(t, "http.response.status_code", s.Attributes[0].Key)
+	assert.Equal(t, "200", *s.Attributes[0].Value.IntValue)
+}
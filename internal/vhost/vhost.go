@@ -0,0 +1,162 @@
+// Package vhost dispatches requests to sites by the Host header, so one
+// listener can serve several names.
+package vhost
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/sunilpar/My-Own-Http-Server/internal/accesslog"
+	"github.com/sunilpar/My-Own-Http-Server/internal/ratelimit"
+	"github.com/sunilpar/My-Own-Http-Server/internal/request"
+	"github.com/sunilpar/My-Own-Http-Server/internal/response"
+	"github.com/sunilpar/My-Own-Http-Server/internal/server"
+)
+
+// Site configures one virtual host. Only Handler is required.
+type Site struct {
+	Handler server.Handler
+
+	// AccessLog, if set, logs this site's requests on top of any server
+	// wide access log.
+	AccessLog *accesslog.Logger
+	// RateLimit, if set, throttles this site's clients.
+	RateLimit *ratelimit.Limiter
+	// MaxConcurrentRequests caps the handlers running for this site at
+	// once, so one busy site cannot starve the others. Requests over the cap
+	// get a 503. Zero means no cap.
+	MaxConcurrentRequests int
+	// Certificate is served to TLS clients asking for this site by SNI.
+	Certificate *tls.Certificate
+}
+
+type site struct {
+	handler server.Handler
+	cert    *tls.Certificate
+}
+
+// Hosts maps host names to sites. A name is either exact, like
+// "example.com", or a wildcard, like "*.example.com", which matches any
+// subdomain but not example.com itself. Exact names beat wildcards and
+// longer wildcards beat shorter ones.
+type Hosts struct {
+	exact     map[string]*site
+	wildcards map[string]*site // keyed by the suffix, ".example.com"
+	fallback  *site
+}
+
+func New() *Hosts {
+	return &Hosts{
+		exact:     make(map[string]*site),
+		wildcards: make(map[string]*site),
+	}
+}
+
+// Add registers s under name. It panics on a malformed or duplicate name,
+// like router.Handle.
+func (h *Hosts) Add(name string, s Site) {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	if name == "" || strings.ContainsAny(name, " /:") || strings.Contains(name[1:], "*") {
+		panic(fmt.Sprintf("vhost: invalid host name %q", name))
+	}
+	table, key := h.exact, name
+	if strings.HasPrefix(name, "*") {
+		if !strings.HasPrefix(name, "*.") || len(name) < 3 {
+			panic(fmt.Sprintf("vhost: invalid wildcard %q", name))
+		}
+		table, key = h.wildcards, name[1:]
+	}
+	if _, ok := table[key]; ok {
+		panic(fmt.Sprintf("vhost: host %q registered twice", name))
+	}
+	table[key] = newSite(s)
+}
+
+// Default sets the site for requests matching no registered name.
+// Without one they get a 404.
+func (h *Hosts) Default(s Site) {
+	h.fallback = newSite(s)
+}
+
+func newSite(s Site) *site {
+	if s.Handler == nil {
+		panic("vhost: site has no handler")
+	}
+	var mw []server.Middleware
+	if s.AccessLog != nil {
+		mw = append(mw, s.AccessLog.Middleware)
+	}
+	if s.MaxConcurrentRequests > 0 {
+		mw = append(mw, concurrencyCap(s.MaxConcurrentRequests))
+	}
+	if s.RateLimit != nil {
+		mw = append(mw, s.RateLimit.Middleware)
+	}
+	return &site{handler: server.NewChain(mw...).Then(s.Handler), cert: s.Certificate}
+}
+
+func concurrencyCap(n int) server.Middleware {
+	slots := make(chan struct{}, n)
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			select {
+			case slots <- struct{}{}:
+				defer func() { <-slots }()
+				next(w, req)
+			default:
+				w.Header.Set("Retry-After", "1")
+				response.WriteText(w, response.StatusServiceUnavailable, "Service Unavailable")
+			}
+		}
+	}
+}
+
+func (h *Hosts) lookup(host string) *site {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if s, ok := h.exact[host]; ok {
+		return s
+	}
+	for i := strings.IndexByte(host, '.'); i >= 0; {
+		if s, ok := h.wildcards[host[i:]]; ok {
+			return s
+		}
+		next := strings.IndexByte(host[i+1:], '.')
+		if next < 0 {
+			break
+		}
+		i += next + 1
+	}
+	return h.fallback
+}
+
+// ServeRequest hands req to the site for its host.
+func (h *Hosts) ServeRequest(w *response.Writer, req *request.Request) {
+	s := h.lookup(req.Hostname())
+	if s == nil {
+		response.WriteText(w, response.StatusNotFound, "Not Found")
+		return
+	}
+	s.handler(w, req)
+}
+
+// GetCertificate picks a certificate by SNI server name, for use as
+// tls.Config.GetCertificate. Clients that send no name get the default
+// site's certificate.
+func (h *Hosts) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s := h.fallback
+	if hello.ServerName != "" {
+		s = h.lookup(hello.ServerName)
+	}
+	if s == nil || s.cert == nil {
+		return nil, errors.New("vhost: no certificate for " + strconv.Quote(hello.ServerName))
+	}
+	return s.cert, nil
+}
+
+// TLSConfig returns a config that serves each site's certificate.
+func (h *Hosts) TLSConfig() *tls.Config {
+	return &tls.Config{GetCertificate: h.GetCertificate}
+}
@@ -0,0 +1,122 @@
+package vhost
+
+import (
+	"bytes"
+	"crypto/tls"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunilpar/My-Own-Http-Server/internal/accesslog"
+	"github.com/sunilpar/My-Own-Http-Server/internal/request"
+	"github.com/sunilpar/My-Own-Http-Server/internal/response"
+	"github.com/sunilpar/My-Own-Http-Server/internal/testutil"
+)
+
+func textSite(name string) Site {
+	return Site{Handler: func(w *response.Writer, req *request.Request) {
+		response.WriteText(w, response.StatusOK, name)
+	}}
+}
+
+func TestHostsLookup(t *testing.T) {
+	h := New()
+	h.Add("example.com", textSite("apex"))
+	h.Add("*.example.com", textSite("any sub"))
+	h.Add("*.api.example.com", textSite("api sub"))
+	h.Add("www.example.com", textSite("www"))
+
+	cases := map[string]string{
+		"example.com":         "apex",
+		"EXAMPLE.com.:42069":  "apex",
+		"www.example.com":     "www",
+		"blog.example.com":    "any sub",
+		"a.b.example.com":     "any sub",
+		"v1.api.example.com":  "api sub",
+		"api.example.com":     "any sub",
+		"other.org":           "404",
+		"[::1]:42069":         "404",
+		"notexample.com":      "404",
+		"www.example.com:443": "www",
+	}
+	for host, want := range cases {
+		resp := testutil.Serve(t, h.ServeRequest, "GET / HTTP/1.1\r\nHost: "+host+"\r\n\r\n")
+		if want == "404" {
+			assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 404 Not Found"), host)
+			continue
+		}
+		assert.True(t, strings.HasSuffix(resp, "\r\n\r\n"+want), host)
+	}
+
+	h.Default(textSite("default"))
+	assert.True(t, strings.HasSuffix(testutil.Serve(t, h.ServeRequest, "GET / HTTP/1.1\r\nHost: other.org\r\n\r\n"), "default"))
+}
+
+func TestInvalidHostNamesPanic(t *testing.T) {
+	h := New()
+	assert.Panics(t, func() { h.Add("", textSite("x")) })
+	assert.Panics(t, func() { h.Add("example.com:80", textSite("x")) })
+	assert.Panics(t, func() { h.Add("*example.com", textSite("x")) })
+	assert.Panics(t, func() { h.Add("www.*.com", textSite("x")) })
+	assert.Panics(t, func() { h.Add("a.com", Site{}) })
+	h.Add("a.com", textSite("x"))
+	assert.Panics(t, func() { h.Add("A.com", textSite("x")) })
+}
+
+func TestSiteAccessLogAndConcurrencyCap(t *testing.T) {
+	var logged bytes.Buffer
+	release := make(chan struct{})
+	started := make(chan struct{})
+	h := New()
+	h.Add("busy.example", Site{
+		Handler: func(w *response.Writer, req *request.Request) {
+			close(started)
+			<-release
+			response.WriteText(w, response.StatusOK, "done")
+		},
+		AccessLog:             accesslog.New(accesslog.Config{Output: &logged}),
+		MaxConcurrentRequests: 1,
+	})
+	h.Add("quiet.example", textSite("quiet"))
+
+	first := make(chan string)
+	go func() { first <- testutil.Serve(t, h.ServeRequest, "GET / HTTP/1.1\r\nHost: busy.example\r\n\r\n") }()
+	<-started
+
+	resp := testutil.Serve(t, h.ServeRequest, "GET / HTTP/1.1\r\nHost: busy.example\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 503 Service Unavailable"))
+	assert.Contains(t, resp, "retry-after: 1\r\n")
+	assert.True(t, strings.HasSuffix(testutil.Serve(t, h.ServeRequest, "GET / HTTP/1.1\r\nHost: quiet.example\r\n\r\n"), "quiet"))
+
+	close(release)
+	assert.True(t, strings.HasSuffix(<-first, "done"))
+	// both busy.example requests are logged, quiet.example is not
+	assert.Equal(t, 2, strings.Count(logged.String(), "\n"))
+}
+
+func TestGetCertificateBySNI(t *testing.T) {
+	apex, wild, fallback := &tls.Certificate{}, &tls.Certificate{}, &tls.Certificate{}
+	h := New()
+	h.Add("example.com", Site{Handler: textSite("a").Handler, Certificate: apex})
+	h.Add("*.example.com", Site{Handler: textSite("b").Handler, Certificate: wild})
+	h.Add("nocert.example.org", textSite("c"))
+
+	cert, err := h.GetCertificate(&tls.ClientHelloInfo{ServerName: "example.com"})
+	require.NoError(t, err)
+	assert.Same(t, apex, cert)
+	cert, err = h.GetCertificate(&tls.ClientHelloInfo{ServerName: "shop.example.com"})
+	require.NoError(t, err)
+	assert.Same(t, wild, cert)
+
+	_, err = h.GetCertificate(&tls.ClientHelloInfo{ServerName: "nocert.example.org"})
+	assert.Error(t, err)
+	_, err = h.GetCertificate(&tls.ClientHelloInfo{})
+	assert.Error(t, err)
+
+	h.Default(Site{Handler: textSite("d").Handler, Certificate: fallback})
+	cert, err = h.GetCertificate(&tls.ClientHelloInfo{})
+	require.NoError(t, err)
+	assert.Same(t, fallback, cert)
+}
@@ -3,15 +3,24 @@ package server_test
 import (
 	"bufio"
 	"fmt"
-	"httpfromtcp/internal/server"
 	"net"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+
+	"github.com/sunilpar/My-Own-Http-Server/internal/request"
+	"github.com/sunilpar/My-Own-Http-Server/internal/response"
+	"github.com/sunilpar/My-Own-Http-Server/pkg/server"
 )
 
+func okHandler(w *response.Writer, req *request.Request) {
+	w.WriteStatusLine(response.StatusOK)
+	w.Header.Set("Content-Length", "0")
+	w.WriteHeaders(w.Header)
+}
+
 func sendRawRequest(t *testing.T, address string, raw string) (string, error) {
 	conn, err := net.Dial("tcp", address)
 	if err != nil {
@@ -33,7 +42,7 @@ func sendRawRequest(t *testing.T, address string, raw string) (string, error) {
 }
 
 func TestValidRequestReturns200(t *testing.T) {
-	s, err := server.Serve(42069)
+	s, err := server.Serve(42069, okHandler)
 	assert.NoError(t, err)
 	defer s.Close()
 
@@ -46,7 +55,7 @@ func TestValidRequestReturns200(t *testing.T) {
 }
 
 func TestMalformedHeaderRequest(t *testing.T) {
-	s, err := server.Serve(42069)
+	s, err := server.Serve(42069, okHandler)
 	assert.NoError(t, err)
 	defer s.Close()
 
@@ -54,8 +63,9 @@ func TestMalformedHeaderRequest(t *testing.T) {
 
 	// Send malformed header (missing colon)
 	raw := "GET / HTTP/1.1\r\nInvalidHeader\r\n\r\n"
-	_, err = sendRawRequest(t, "localhost:42069", raw)
+	resp, err := sendRawRequest(t, "localhost:42069", raw)
 
-	// Expect error or empty response (because server logs and closes)
-	assert.Error(t, err)
+	// The server answers 400 before it closes the connection
+	assert.NoError(t, err)
+	assert.True(t, strings.Contains(resp, "400 Bad Request"))
 }